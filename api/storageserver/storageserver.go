@@ -63,3 +63,164 @@ func (s *StorageServer) Delete(command models.DeleteCommand, reply *bool) error
 
 	return err
 }
+
+func (s *StorageServer) DeleteRange(command models.DeleteRangeCommand, reply *bool) error {
+
+	err := s.storageService.DeleteRange(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+func (s *StorageServer) Merge(command models.MergeCommand, reply *bool) error {
+
+	err := s.storageService.Merge(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+// CompareAndSwap replies whether the condition held and the key was written.
+func (s *StorageServer) CompareAndSwap(command models.CompareAndSwapCommand, reply *bool) error {
+
+	swapped, err := s.storageService.CompareAndSwap(command)
+
+	*reply = swapped
+
+	return err
+}
+
+func (s *StorageServer) PutIfAbsent(command models.PutIfAbsentCommand, reply *bool) error {
+
+	put, err := s.storageService.PutIfAbsent(command)
+
+	*reply = put
+
+	return err
+}
+
+func (s *StorageServer) DeleteIfEquals(command models.DeleteIfEqualsCommand, reply *bool) error {
+
+	deleted, err := s.storageService.DeleteIfEquals(command)
+
+	*reply = deleted
+
+	return err
+}
+
+func (s *StorageServer) WriteBatch(command models.WriteBatchCommand, reply *bool) error {
+
+	err := s.storageService.WriteBatch(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = true
+
+	return nil
+}
+
+func (s *StorageServer) Scan(command models.ScanCommand, reply *[]models.KeyValue) error {
+
+	items, err := s.storageService.Scan(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = items
+
+	return nil
+}
+
+func (s *StorageServer) Stats(command models.StatsCommand, reply *models.Stats) error {
+
+	stats, err := s.storageService.Stats(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = *stats
+
+	return nil
+}
+
+func (s *StorageServer) BeginTransaction(command models.BeginTransactionCommand, reply *uint64) error {
+
+	transactionID, err := s.storageService.BeginTransaction(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = transactionID
+
+	return nil
+}
+
+func (s *StorageServer) TransactionGet(command models.TransactionCommand, reply *string) error {
+
+	result, err := s.storageService.TransactionGet(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = result
+
+	return nil
+}
+
+func (s *StorageServer) TransactionPut(command models.TransactionCommand, reply *bool) error {
+
+	err := s.storageService.TransactionPut(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+func (s *StorageServer) TransactionDelete(command models.TransactionCommand, reply *bool) error {
+
+	err := s.storageService.TransactionDelete(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+func (s *StorageServer) CommitTransaction(command models.TransactionCommand, reply *bool) error {
+
+	err := s.storageService.CommitTransaction(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+func (s *StorageServer) RollbackTransaction(command models.TransactionCommand, reply *bool) error {
+
+	err := s.storageService.RollbackTransaction(command)
+
+	*reply = err == nil
+
+	return err
+}
+
+// LockStatus is an admin call listing which transaction holds which key lock.
+func (s *StorageServer) LockStatus(command models.LockStatusCommand, reply *[]models.LockInfo) error {
+
+	locks, err := s.storageService.LockStatus(command)
+
+	if err != nil {
+		return err
+	}
+
+	*reply = locks
+
+	return nil
+}
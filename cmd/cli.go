@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"pkvstore/pkg/models"
 	"pkvstore/pkg/storageclient"
 )
 
+const usage = "expected 'get', 'put', 'delete', 'delete-range', 'merge', 'cas', 'put-if-absent', 'delete-if-equals', 'scan', 'stats', 'begin', 'commit', 'rollback' or 'locks' subcommands"
+
 type CommandInterface struct {
 	client *storageclient.StorageClient
 }
@@ -18,9 +22,20 @@ func main() {
 	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
 	putCmd := flag.NewFlagSet("put", flag.ExitOnError)
 	deleteCmd := flag.NewFlagSet("delete", flag.ExitOnError)
+	deleteRangeCmd := flag.NewFlagSet("delete-range", flag.ExitOnError)
+	mergeCmd := flag.NewFlagSet("merge", flag.ExitOnError)
+	casCmd := flag.NewFlagSet("cas", flag.ExitOnError)
+	putIfAbsentCmd := flag.NewFlagSet("put-if-absent", flag.ExitOnError)
+	deleteIfEqualsCmd := flag.NewFlagSet("delete-if-equals", flag.ExitOnError)
+	scanCmd := flag.NewFlagSet("scan", flag.ExitOnError)
+	statsCmd := flag.NewFlagSet("stats", flag.ExitOnError)
+	beginCmd := flag.NewFlagSet("begin", flag.ExitOnError)
+	commitCmd := flag.NewFlagSet("commit", flag.ExitOnError)
+	rollbackCmd := flag.NewFlagSet("rollback", flag.ExitOnError)
+	locksCmd := flag.NewFlagSet("locks", flag.ExitOnError)
 
 	if len(os.Args) < 2 {
-		fmt.Println("expected 'get', 'put' or 'delete' subcommands")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 
@@ -31,8 +46,30 @@ func main() {
 		cli.handlePut(putCmd)
 	case "delete":
 		cli.handleDelete(deleteCmd)
+	case "delete-range":
+		cli.handleDeleteRange(deleteRangeCmd)
+	case "merge":
+		cli.handleMerge(mergeCmd)
+	case "cas":
+		cli.handleCompareAndSwap(casCmd)
+	case "put-if-absent":
+		cli.handlePutIfAbsent(putIfAbsentCmd)
+	case "delete-if-equals":
+		cli.handleDeleteIfEquals(deleteIfEqualsCmd)
+	case "scan":
+		cli.handleScan(scanCmd)
+	case "stats":
+		cli.handleStats(statsCmd)
+	case "begin":
+		cli.handleBegin(beginCmd)
+	case "commit":
+		cli.handleCommit(commitCmd)
+	case "rollback":
+		cli.handleRollback(rollbackCmd)
+	case "locks":
+		cli.handleLocks(locksCmd)
 	default:
-		fmt.Println("expected 'get', 'put' or 'delete' subcommands")
+		fmt.Println(usage)
 		os.Exit(1)
 	}
 }
@@ -41,8 +78,25 @@ func (cli *CommandInterface) handleGet(getCmd *flag.FlagSet) {
 
 	key := getCmd.String("key", "", "Key of the item")
 
+	txn := getCmd.Uint64("txn", 0, "ID of the transaction to read in, 0 for none")
+
+	forUpdate := getCmd.Bool("for-update", false, "Lock the key in a pessimistic transaction")
+
 	getCmd.Parse(os.Args[2:])
 
+	if *txn != 0 {
+		transactionGet := cli.client.TransactionGet
+		if *forUpdate {
+			transactionGet = cli.client.TransactionGetForUpdate
+		}
+
+		value, err := transactionGet(*txn, *key)
+		exitOnError(err)
+
+		fmt.Println("GET operation - Transaction:", *txn, "Key:", *key, " value: ", value)
+		return
+	}
+
 	value := cli.client.Get(*key)
 
 	fmt.Println("GET operation - Key:", *key, " value: ", value)
@@ -54,8 +108,34 @@ func (cli *CommandInterface) handlePut(putCmd *flag.FlagSet) {
 
 	value := putCmd.String("value", "", "Value of the item")
 
+	txn := putCmd.Uint64("txn", 0, "ID of the transaction to write in, 0 for none")
+
+	ttl := putCmd.Duration("ttl", 0, "How long the item lives, such as 30s or 1h, 0 for ever")
+
 	putCmd.Parse(os.Args[2:])
 
+	if *ttl < 0 {
+		exitOnError(errors.New("-ttl must be positive"))
+	}
+
+	if *txn != 0 && *ttl != 0 {
+		exitOnError(errors.New("-ttl is not supported in transactions"))
+	}
+
+	if *ttl != 0 {
+		cli.client.PutWithTTL(*key, *value, *ttl)
+
+		fmt.Println("PUT operation - Key:", *key, "Value:", *value, "TTL:", *ttl)
+		return
+	}
+
+	if *txn != 0 {
+		exitOnError(cli.client.TransactionPut(*txn, *key, *value))
+
+		fmt.Println("PUT operation - Transaction:", *txn, "Key:", *key, "Value:", *value)
+		return
+	}
+
 	cli.client.Put(*key, *value)
 
 	fmt.Println("PUT operation - Key:", *key, "Value:", *value)
@@ -65,9 +145,200 @@ func (cli *CommandInterface) handleDelete(deleteCmd *flag.FlagSet) {
 
 	key := deleteCmd.String("key", "", "Key of the item")
 
+	txn := deleteCmd.Uint64("txn", 0, "ID of the transaction to delete in, 0 for none")
+
 	deleteCmd.Parse(os.Args[2:])
 
+	if *txn != 0 {
+		exitOnError(cli.client.TransactionDelete(*txn, *key))
+
+		fmt.Println("DELETE operation - Transaction:", *txn, "Key:", *key)
+		return
+	}
+
 	cli.client.Delete(*key)
 
 	fmt.Println("DELETE operation - Key:", *key)
 }
+
+func (cli *CommandInterface) handleDeleteRange(deleteRangeCmd *flag.FlagSet) {
+
+	start := deleteRangeCmd.String("start", "", "First key to delete")
+
+	end := deleteRangeCmd.String("end", "", "Key after the last one to delete, empty for no upper bound")
+
+	prefix := deleteRangeCmd.String("prefix", "", "Delete every key with this prefix instead of a range")
+
+	all := deleteRangeCmd.Bool("all", false, "Delete every key in the store")
+
+	deleteRangeCmd.Parse(os.Args[2:])
+
+	bounded := *start != "" || *end != "" || *prefix != ""
+
+	if *all && bounded {
+		exitOnError(errors.New("-all cannot be combined with -start, -end or -prefix"))
+	}
+
+	if !*all && !bounded {
+		exitOnError(errors.New("expected -start, -end or -prefix, or -all to delete every key"))
+	}
+
+	cli.client.DeleteRange(models.DeleteRangeCommand{Start: *start, End: *end, Prefix: *prefix, All: *all})
+
+	if *all {
+		fmt.Println("DELETE-RANGE operation - All keys")
+		return
+	}
+
+	fmt.Println("DELETE-RANGE operation - Start:", *start, "End:", *end, "Prefix:", *prefix)
+}
+
+func (cli *CommandInterface) handleMerge(mergeCmd *flag.FlagSet) {
+
+	key := mergeCmd.String("key", "", "Key of the item")
+
+	operand := mergeCmd.String("value", "", "Operand to merge into the item")
+
+	mergeCmd.Parse(os.Args[2:])
+
+	exitOnError(cli.client.Merge(*key, *operand))
+
+	fmt.Println("MERGE operation - Key:", *key, "Operand:", *operand)
+}
+
+func (cli *CommandInterface) handleCompareAndSwap(casCmd *flag.FlagSet) {
+
+	key := casCmd.String("key", "", "Key of the item")
+
+	expected := casCmd.String("expected", "", "Value the item must hold")
+
+	value := casCmd.String("value", "", "New value of the item")
+
+	casCmd.Parse(os.Args[2:])
+
+	swapped := cli.client.CompareAndSwap(*key, *expected, *value)
+
+	fmt.Println("CAS operation - Key:", *key, "Expected:", *expected, "Value:", *value, "Swapped:", swapped)
+}
+
+func (cli *CommandInterface) handlePutIfAbsent(putIfAbsentCmd *flag.FlagSet) {
+
+	key := putIfAbsentCmd.String("key", "", "Key of the item")
+
+	value := putIfAbsentCmd.String("value", "", "Value of the item")
+
+	putIfAbsentCmd.Parse(os.Args[2:])
+
+	put := cli.client.PutIfAbsent(*key, *value)
+
+	fmt.Println("PUT-IF-ABSENT operation - Key:", *key, "Value:", *value, "Put:", put)
+}
+
+func (cli *CommandInterface) handleDeleteIfEquals(deleteIfEqualsCmd *flag.FlagSet) {
+
+	key := deleteIfEqualsCmd.String("key", "", "Key of the item")
+
+	expected := deleteIfEqualsCmd.String("expected", "", "Value the item must hold")
+
+	deleteIfEqualsCmd.Parse(os.Args[2:])
+
+	deleted := cli.client.DeleteIfEquals(*key, *expected)
+
+	fmt.Println("DELETE-IF-EQUALS operation - Key:", *key, "Expected:", *expected, "Deleted:", deleted)
+}
+
+func (cli *CommandInterface) handleScan(scanCmd *flag.FlagSet) {
+
+	start := scanCmd.String("start", "", "First key of the range")
+
+	end := scanCmd.String("end", "", "Key the range stops before")
+
+	prefix := scanCmd.String("prefix", "", "Prefix of the keys, overrides start and end")
+
+	limit := scanCmd.Int("limit", 0, "Maximum number of items, 0 for all")
+
+	scanCmd.Parse(os.Args[2:])
+
+	items := cli.client.Scan(models.ScanCommand{Start: *start, End: *end, Prefix: *prefix, Limit: *limit})
+
+	for _, item := range items {
+		fmt.Println("Key:", item.Key, " value: ", item.Value)
+	}
+
+	fmt.Println("SCAN operation -", len(items), "items")
+}
+
+func (cli *CommandInterface) handleStats(statsCmd *flag.FlagSet) {
+
+	statsCmd.Parse(os.Args[2:])
+
+	stats := cli.client.Stats()
+
+	fmt.Println("Active memtable size:", stats.ActiveMemtableSize, "bytes")
+	fmt.Println("Immutable memtables:", stats.ImmutableMemtables)
+	fmt.Println("SSTables per level:", stats.SSTablesPerLevel)
+	fmt.Println("Bytes per level:", stats.BytesPerLevel)
+	fmt.Println("Compaction style:", stats.CompactionStyle)
+	fmt.Println("Dropped SSTables:", stats.DroppedSSTables, "of", stats.DroppedBytes, "bytes")
+	fmt.Println("Write stall:", stats.WriteStallState)
+	fmt.Println("Delayed writes:", stats.DelayedWrites, "for", stats.DelayedDuration)
+	fmt.Println("Stopped writes:", stats.StoppedWrites, "for", stats.StoppedDuration)
+}
+
+func (cli *CommandInterface) handleBegin(beginCmd *flag.FlagSet) {
+
+	pessimistic := beginCmd.Bool("pessimistic", false, "Lock the keys the transaction touches instead of checking for conflicts on commit")
+
+	lockTimeout := beginCmd.Int("lock-timeout", 0, "Milliseconds to wait for each key lock, 0 for the server's default")
+
+	beginCmd.Parse(os.Args[2:])
+
+	var txn uint64
+
+	if *pessimistic {
+		txn = cli.client.BeginPessimisticTransaction(*lockTimeout)
+	} else {
+		txn = cli.client.BeginTransaction()
+	}
+
+	fmt.Println("BEGIN operation - Transaction:", txn)
+}
+
+func (cli *CommandInterface) handleCommit(commitCmd *flag.FlagSet) {
+
+	txn := commitCmd.Uint64("txn", 0, "ID of the transaction")
+
+	commitCmd.Parse(os.Args[2:])
+
+	exitOnError(cli.client.CommitTransaction(*txn))
+
+	fmt.Println("COMMIT operation - Transaction:", *txn)
+}
+
+func (cli *CommandInterface) handleRollback(rollbackCmd *flag.FlagSet) {
+
+	txn := rollbackCmd.Uint64("txn", 0, "ID of the transaction")
+
+	rollbackCmd.Parse(os.Args[2:])
+
+	exitOnError(cli.client.RollbackTransaction(*txn))
+
+	fmt.Println("ROLLBACK operation - Transaction:", *txn)
+}
+
+func (cli *CommandInterface) handleLocks(locksCmd *flag.FlagSet) {
+
+	locksCmd.Parse(os.Args[2:])
+
+	for _, lock := range cli.client.LockStatus() {
+		fmt.Println("Key:", lock.Key, "Transaction:", lock.TransactionID, "Waiting:", lock.Waiters)
+	}
+}
+
+func exitOnError(err error) {
+
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+}
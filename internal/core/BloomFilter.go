@@ -1,10 +1,18 @@
 package core
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
 	"github.com/devopsfaith/bloomfilter"
 	baseBloomfilter "github.com/devopsfaith/bloomfilter/bloomfilter"
 )
 
+var ErrInvalidBloomFilter = errors.New("bloomfilter: invalid encoding")
+
 type BloomFilter struct {
 	bloomFilter *baseBloomfilter.Bloomfilter
 	config      *BloomFilterConfig
@@ -50,3 +58,83 @@ func (bf *BloomFilter) Exist(element []byte) bool {
 func (bf *BloomFilter) DoesNotExist(element []byte) bool {
 	return !bf.Exist(element)
 }
+
+func (bf *BloomFilter) Config() *BloomFilterConfig {
+	return bf.config
+}
+
+// MarshalBinary encodes the filter bits together with the N, P and hash name
+// the filter was built with:
+//
+//	n (uint64) | p (float64 bits) | hash name length (uint32) | hash name | bits length (uint32) | bits
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	bits, err := bf.bloomFilter.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, 0, 8+8+4+len(bf.config.HashName)+4+len(bits))
+	data = binary.LittleEndian.AppendUint64(data, uint64(bf.config.N))
+	data = binary.LittleEndian.AppendUint64(data, math.Float64bits(bf.config.P))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(bf.config.HashName)))
+	data = append(data, bf.config.HashName...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(bits)))
+	data = append(data, bits...)
+
+	return data, nil
+}
+
+// UnmarshalBloomFilter decodes a filter written by MarshalBinary.
+func UnmarshalBloomFilter(data []byte) (*BloomFilter, error) {
+	reader := bytes.NewReader(data)
+
+	var n, p uint64
+	if err := binary.Read(reader, binary.LittleEndian, &n); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	hashName, err := readBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := bloomfilter.HashFactoryNames[string(hashName)]; !ok {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	bits, err := readBytes(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	base := new(baseBloomfilter.Bloomfilter)
+	if err := base.UnmarshalBinary(bits); err != nil {
+		return nil, err
+	}
+
+	return &BloomFilter{
+		bloomFilter: base,
+		config:      NewBloomFilterConfig(uint(n), math.Float64frombits(p), string(hashName)),
+	}, nil
+}
+
+func readBytes(reader *bytes.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	if int64(length) > int64(reader.Len()) {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, ErrInvalidBloomFilter
+	}
+
+	return data, nil
+}
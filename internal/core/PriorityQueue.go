@@ -2,6 +2,7 @@ package core
 
 type Item struct {
 	SortKey   string
+	Sequence  uint64
 	SSTableID int
 	BlockID   int
 	EntryID   int
@@ -15,10 +16,14 @@ func (mpq PriorityQueue) Len() int {
 }
 
 func (mpq PriorityQueue) Less(i, j int) bool {
-	if mpq[i].SortKey == mpq[j].SortKey {
-		return mpq[i].SSTableID > mpq[j].SSTableID
+	if mpq[i].SortKey != mpq[j].SortKey {
+		return mpq[i].SortKey < mpq[j].SortKey
 	}
-	return mpq[i].SortKey < mpq[j].SortKey
+	if mpq[i].Sequence != mpq[j].Sequence {
+		// newer versions of a key come first
+		return mpq[i].Sequence > mpq[j].Sequence
+	}
+	return mpq[i].SSTableID > mpq[j].SSTableID
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
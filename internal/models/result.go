@@ -7,17 +7,22 @@ const (
 	NotFound
 	Deleted
 	ContinueSearch
+	MergeOperand // the newest version is a merge operand; the older ones are needed to resolve it
 )
 
+// Result is the outcome of a read. Sequence is the sequence number of the
+// version found or of the tombstone that deleted the key.
 type Result struct {
-	Status ResultStatus
-	Value  string
+	Status   ResultStatus
+	Value    string
+	Sequence uint64
 }
 
-func NewFoundResult(value string) *Result {
+func NewFoundResult(value string, sequence uint64) *Result {
 	return &Result{
-		Status: Found,
-		Value:  value,
+		Status:   Found,
+		Value:    value,
+		Sequence: sequence,
 	}
 }
 
@@ -27,9 +32,17 @@ func NewNotFoundResult() *Result {
 	}
 }
 
-func NewDeletedResult() *Result {
+func NewDeletedResult(sequence uint64) *Result {
 	return &Result{
-		Status: Deleted,
+		Status:   Deleted,
+		Sequence: sequence,
+	}
+}
+
+func NewMergeOperandResult(sequence uint64) *Result {
+	return &Result{
+		Status:   MergeOperand,
+		Sequence: sequence,
 	}
 }
 
@@ -2,32 +2,64 @@ package backgroundprocess
 
 import (
 	"container/heap"
+	"log"
 	"pkvstore/internal/core"
 	"pkvstore/internal/storageengine/channels"
+	"pkvstore/internal/storageengine/compactionfilter"
 	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
 	"pkvstore/internal/storageengine/lsmtree"
 	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/mergeoperator"
 	"pkvstore/internal/storageengine/sstable"
-	"sort"
+	"sync"
+	"time"
 )
 
 type Compaction struct {
 	lsmTree    *lsmtree.LSMTree
 	sharedChan *channels.SharedChannel
+	picker     CompactionPicker
+
+	filter      compactionfilter.CompactionFilter
+	filterMutex sync.Mutex
 }
 
 func NewCompaction(lsmtree *lsmtree.LSMTree) *Compaction {
 	compaction := &Compaction{
 		lsmTree:    lsmtree,
 		sharedChan: channels.GetSharedChannel(),
+		picker:     newCompactionPicker(configs.GetStorageEngineConfig().LSMTreeConfig.CompactionStyle),
 	}
 
 	go compaction.listenFlushMemtable()
 	go compaction.listenToCompact()
 
+	// FIFO compaction deletes tables by age even when no flush happens
+	config := configs.GetStorageEngineConfig()
+	if config.LSMTreeConfig.CompactionStyle == configs.FIFOCompaction && config.FIFOCompactionConfig.MaxAgeMs > 0 {
+		go compaction.compactPeriodically(time.Duration(config.FIFOCompactionConfig.MaxAgeMs) * time.Millisecond)
+	}
+
 	return compaction
 }
 
+// SetCompactionFilter makes the compactions that start from now on pass
+// their values through filter. A nil filter keeps every value.
+func (compaction *Compaction) SetCompactionFilter(filter compactionfilter.CompactionFilter) {
+	compaction.filterMutex.Lock()
+	defer compaction.filterMutex.Unlock()
+
+	compaction.filter = filter
+}
+
+func (compaction *Compaction) compactionFilter() compactionfilter.CompactionFilter {
+	compaction.filterMutex.Lock()
+	defer compaction.filterMutex.Unlock()
+
+	return compaction.filter
+}
+
 func (compaction *Compaction) listenToCompact() {
 
 	for event := range compaction.sharedChan.CompactionEvent {
@@ -40,95 +72,192 @@ func (compaction *Compaction) listenToCompact() {
 	}
 }
 
+// compactPeriodically asks for a compaction every interval. A pending request
+// already covers the tick.
+func (compaction *Compaction) compactPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		select {
+		case compaction.sharedChan.CompactionEvent <- 1:
+		default:
+		}
+	}
+}
+
 func (compaction *Compaction) listenFlushMemtable() {
 
 	for event := range compaction.sharedChan.FlushMemtableEvent {
 
-		if event < 1 || compaction.lsmTree.MemTable.ReadOnlyTable == nil {
+		if event < 1 {
 			continue
 		}
 
-		newSSTable := createSSTableFromMemtable(compaction.lsmTree.MemTable.ReadOnlyTable)
-		compaction.lsmTree.SSTables[newSSTable.Header.Level] = append(compaction.lsmTree.SSTables[newSSTable.Header.Level], newSSTable)
-		compaction.lsmTree.MemTable.ClearReadOnlyMemtable()
+		// a slow flush lets several memtables queue up; flush them oldest first
+		for immutable := compaction.lsmTree.MemTable.OldestImmutable(); immutable != nil; immutable = compaction.lsmTree.MemTable.OldestImmutable() {
+			if !compaction.flushMemtable(immutable) {
+				break
+			}
+		}
+	}
+}
+
+// flushMemtable writes an immutable memtable to a first-level SSTable and
+// reports whether it succeeded.
+func (compaction *Compaction) flushMemtable(immutable *memtable.SkipList) bool {
+	config := configs.GetStorageEngineConfig()
+
+	newSSTable := createSSTableFromMemtable(immutable, compaction.lsmTree.SnapshotSequences())
+	newSSTable.FileNumber = compaction.lsmTree.NewFileNumber()
 
-		compaction.sharedChan.CompactionEvent <- 1
+	// the memtable and its WAL segments stay until the table is on disk
+	if err := newSSTable.WriteToFile(config.SSTableConfig.Directory); err != nil {
+		log.Println("Writing flushed SSTable:", err)
+		return false
 	}
+
+	if err := compaction.lsmTree.AddSSTable(newSSTable); err != nil {
+		log.Println("Recording flushed SSTable:", err)
+		return false
+	}
+
+	if err := compaction.lsmTree.CompleteMemtableFlush(); err != nil {
+		log.Println("Removing flushed WAL segments:", err)
+	}
+
+	compaction.sharedChan.CompactionEvent <- 1
+
+	return true
 }
 
 func (compaction *Compaction) tryCompactionProcess() {
+	for job := compaction.picker.PickCompaction(compaction.lsmTree); job != nil; job = compaction.picker.PickCompaction(compaction.lsmTree) {
+		if !compaction.runCompaction(job) {
+			break
+		}
+	}
+}
+
+// runCompaction merges the inputs of job into the output level and reports
+// whether it succeeded.
+func (compaction *Compaction) runCompaction(job *CompactionJob) bool {
 	config := configs.GetStorageEngineConfig()
 
-	for level := config.LSMTreeConfig.FirstLevel; level >= 0; level-- {
-		if len(compaction.lsmTree.SSTables[level]) <= 1<<level {
-			break
+	if job.Drop {
+		if err := compaction.lsmTree.DropSSTables(job.Inputs); err != nil {
+			log.Println("Dropping SSTables:", err)
+			return false
 		}
 
-		currentLevel := uint8(level)
+		return true
+	}
+
+	context := compactionfilter.Context{
+		InputLevel:  uint8(job.InputLevel),
+		OutputLevel: uint8(job.OutputLevel),
+		Bottommost:  compaction.isBottommost(job.OutputLevel),
+	}
+	mergedSSTables, err := mergeGetSSTables(job.Inputs, context, compaction.lsmTree.SnapshotSequences(), compaction.lsmTree.MergeOperator(), compaction.compactionFilter())
+
+	if err != nil {
+		log.Println("Merging SSTables:", err)
+		return false
+	}
+
+	for _, mergedSSTable := range mergedSSTables {
+		mergedSSTable.FileNumber = compaction.lsmTree.NewFileNumber()
 
-		if level > 0 {
-			compaction.mergeAndReplaceSSTables(currentLevel, currentLevel-1)
-		} else {
-			compaction.mergeAndReplaceSSTables(currentLevel, currentLevel)
+		if err := mergedSSTable.WriteToFile(config.SSTableConfig.Directory); err != nil {
+			log.Println("Writing compacted SSTable:", err)
+			return false
 		}
 	}
-}
 
-func (compaction *Compaction) mergeAndReplaceSSTables(currentLevel, newLevel uint8) {
-	mergedSSTable := mergeGetSSTables(compaction.lsmTree.SSTables[currentLevel], newLevel)
-	compaction.lsmTree.SSTables[currentLevel] = make([]*sstable.SSTable, 0)
-	compaction.lsmTree.SSTables[newLevel] = append(compaction.lsmTree.SSTables[newLevel], mergedSSTable)
+	if err := compaction.lsmTree.ReplaceSSTables(job.Inputs, mergedSSTables); err != nil {
+		log.Println("Recording compacted SSTable:", err)
+		return false
+	}
+
+	return true
 }
 
-func createSSTableFromMemtable(memTable map[string]*memtable.MemTableEntry) *sstable.SSTable {
-	sstableEntries := make([]*sstable.SSTableEntry, 0)
+// isBottommost reports whether the levels below level are empty. The tables
+// of the output level a compaction leaves out hold none of its keys, so
+// nothing older than its inputs remains then. Only compactions add tables
+// below the first level, so the answer holds until the compaction is done.
+func (compaction *Compaction) isBottommost(level int) bool {
 	config := configs.GetStorageEngineConfig()
 
-	for k, v := range memTable {
-		sstableEntries = append(sstableEntries, sstable.NewSSTableEntry(k, v.Value, v.IsTombstone))
+	for below := level - 1; below >= config.LSMTreeConfig.LastLevel; below-- {
+		if len(compaction.lsmTree.GetSSTables(below)) > 0 {
+			return false
+		}
 	}
 
-	sort.Slice(sstableEntries, func(i, j int) bool {
-		return sstableEntries[i].Key < sstableEntries[j].Key
-	})
+	return true
+}
+
+func createSSTableFromMemtable(memTable *memtable.SkipList, snapshots []uint64) *sstable.SSTable {
+	config := configs.GetStorageEngineConfig()
 
-	return sstable.CreateSSTable(sstableEntries, uint8(config.LSMTreeConfig.FirstLevel))
+	return sstable.CreateSSTable(memTable.NewIterator(), memTable.RangeTombstones(), uint(memTable.Len()), uint8(config.LSMTreeConfig.FirstLevel), snapshots)
 }
 
-func mergeGetSSTables(sstablesInLevel []*sstable.SSTable, newLevel uint8) *sstable.SSTable {
+// mergeGetSSTables merges the tables into tables of about
+// LSMTreeConfig.TargetFileSize at the output level of context. Of the versions
+// of a key, only the newest one and those still visible to one of the
+// snapshots, given as ascending sequence numbers, are kept, with merge operands
+// combined into them by operator when it is not nil. Versions deleted by a
+// range tombstone of the tables are dropped. When the output is bottommost, no
+// older versions exist outside the tables, so range tombstones no snapshot
+// needs are dropped too. filter, when not nil, decides on the values no
+// snapshot sees.
+func mergeGetSSTables(sstablesInLevel []*sstable.SSTable, context compactionfilter.Context, snapshots []uint64, operator mergeoperator.MergeOperator, filter compactionfilter.CompactionFilter) ([]*sstable.SSTable, error) {
 	frontier := make(core.PriorityQueue, 0)
 	numberEntries := uint(0)
 
+	// only the block under each table's cursor is held in memory
+	currentBlocks := make([][]*sstable.SSTableEntry, len(sstablesInLevel))
+
+	rangeTombstones := make([]iterator.RangeTombstone, 0)
+
 	for sstableID, ssTable := range sstablesInLevel {
 		numberEntries += ssTable.Header.NumberEntries
+		rangeTombstones = append(rangeTombstones, ssTable.RangeTombstones...)
+
+		if len(ssTable.Blocks) == 0 {
+			continue
+		}
+
+		firstBlock, err := ssTable.BlockEntries(0)
+		if err != nil {
+			return nil, err
+		}
+		currentBlocks[sstableID] = firstBlock
 
-		firstBlock := ssTable.Blocks[0]
 		heap.Push(&frontier, &core.Item{
-			SortKey:   firstBlock.Entries[0].Key,
+			SortKey:   firstBlock[0].Key,
+			Sequence:  firstBlock[0].Sequence,
 			SSTableID: sstableID,
 			BlockID:   0,
 			EntryID:   0,
 		})
 	}
 
-	newSSTable := sstable.OpenSSTable(newLevel, numberEntries)
-	lastKey := ""
+	compactor := newVersionCompactor(numberEntries, snapshots, rangeTombstones, operator, filter, context)
 
 	for len(frontier) > 0 {
 		item := heap.Pop(&frontier).(*core.Item)
 
-		// Deduplication
-		entry := sstablesInLevel[item.SSTableID].Blocks[item.BlockID].Entries[item.EntryID]
+		// Deduplication: the newest version of a key comes out first
+		block := currentBlocks[item.SSTableID]
+		compactor.add(block[item.EntryID])
 
-		if lastKey != item.SortKey {
-			newSSTable.AddEntry(entry)
-			lastKey = entry.Key
-		}
-
-		if item.EntryID+1 < len(sstablesInLevel[item.SSTableID].Blocks[item.BlockID].Entries) {
-			block := sstablesInLevel[item.SSTableID].Blocks[item.BlockID]
+		if item.EntryID+1 < len(block) {
 			heap.Push(&frontier, &core.Item{
-				SortKey:   block.Entries[item.EntryID+1].Key,
+				SortKey:   block[item.EntryID+1].Key,
+				Sequence:  block[item.EntryID+1].Sequence,
 				SSTableID: item.SSTableID,
 				BlockID:   item.BlockID,
 				EntryID:   item.EntryID + 1,
@@ -138,9 +267,15 @@ func mergeGetSSTables(sstablesInLevel []*sstable.SSTable, newLevel uint8) *sstab
 		}
 
 		if item.BlockID+1 < len(sstablesInLevel[item.SSTableID].Blocks) {
-			block := sstablesInLevel[item.SSTableID].Blocks[item.BlockID+1]
+			nextBlock, err := sstablesInLevel[item.SSTableID].BlockEntries(item.BlockID + 1)
+			if err != nil {
+				return nil, err
+			}
+			currentBlocks[item.SSTableID] = nextBlock
+
 			heap.Push(&frontier, &core.Item{
-				SortKey:   block.Entries[0].Key,
+				SortKey:   nextBlock[0].Key,
+				Sequence:  nextBlock[0].Sequence,
 				SSTableID: item.SSTableID,
 				BlockID:   item.BlockID + 1,
 				EntryID:   0,
@@ -148,5 +283,5 @@ func mergeGetSSTables(sstablesInLevel []*sstable.SSTable, newLevel uint8) *sstab
 		}
 	}
 
-	return newSSTable.CompleteSSTableCreation()
+	return compactor.finish(), nil
 }
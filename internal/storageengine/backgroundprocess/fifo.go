@@ -0,0 +1,76 @@
+package backgroundprocess
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/sstable"
+	"sort"
+	"time"
+)
+
+// fifoPicker never merges tables. Flushed tables stay in the first level
+// until they are the oldest and the tables add up to more than
+// FIFOCompactionConfig.MaxTableFilesSize bytes, or until they are older than
+// FIFOCompactionConfig.MaxAgeMs; then they are deleted along with their keys.
+// It suits data that is only ever appended and read while recent, such as
+// time series and logs.
+type fifoPicker struct{}
+
+func (picker *fifoPicker) Name() string {
+	return "fifo"
+}
+
+func (picker *fifoPicker) PickCompaction(tree *lsmtree.LSMTree) *CompactionJob {
+	config := configs.GetStorageEngineConfig()
+	maxSize := config.FIFOCompactionConfig.MaxTableFilesSize
+	maxAge := time.Duration(config.FIFOCompactionConfig.MaxAgeMs) * time.Millisecond
+
+	tables := picker.oldestFirst(tree)
+	size := levelSize(tables)
+	now := time.Now().UnixNano()
+
+	dropped := make([]*sstable.SSTable, 0)
+
+	for _, table := range tables {
+		tooLarge := maxSize > 0 && size > maxSize
+		tooOld := maxAge > 0 && now-table.Header.Timestamp > int64(maxAge)
+
+		if !tooLarge && !tooOld {
+			break
+		}
+
+		dropped = append(dropped, table)
+		size -= table.Size()
+	}
+
+	if len(dropped) == 0 {
+		return nil
+	}
+
+	return &CompactionJob{
+		InputLevel:  config.LSMTreeConfig.FirstLevel,
+		OutputLevel: config.LSMTreeConfig.FirstLevel,
+		Inputs:      dropped,
+		Drop:        true,
+	}
+}
+
+// oldestFirst lists the tables of tree from the oldest data to the newest.
+// Tables left below the first level by another compaction style hold older
+// data than the first level, the lowest level the oldest.
+func (picker *fifoPicker) oldestFirst(tree *lsmtree.LSMTree) []*sstable.SSTable {
+	config := configs.GetStorageEngineConfig()
+
+	tables := make([]*sstable.SSTable, 0)
+
+	for level := config.LSMTreeConfig.LastLevel; level < config.LSMTreeConfig.FirstLevel; level++ {
+		tables = append(tables, tree.GetSSTables(level)...)
+	}
+
+	firstLevel := tree.GetSSTables(config.LSMTreeConfig.FirstLevel)
+	sort.SliceStable(firstLevel, func(i, j int) bool {
+		return firstLevel[i].Header.Timestamp < firstLevel[j].Header.Timestamp
+	})
+
+	return append(tables, firstLevel...)
+}
@@ -0,0 +1,101 @@
+package backgroundprocess
+
+import (
+	"fmt"
+	"pkvstore/internal/storageengine/configs"
+	"testing"
+	"time"
+)
+
+func TestFIFOPickCompaction(t *testing.T) {
+	config := configs.GetStorageEngineConfig()
+	first, last := config.LSMTreeConfig.FirstLevel, config.LSMTreeConfig.LastLevel
+
+	tests := []struct {
+		name string
+		// the tables fit within this many of them, 0 for no size limit
+		maxTables int
+		maxAge    time.Duration
+		// levels of the tables in the order they are written, and whether
+		// each is older than maxAge
+		levels []int
+		old    []bool
+		want   string // the job, nil for none
+	}{
+		{
+			name:   "no limits",
+			levels: []int{first, first, first},
+			want:   "nil",
+		},
+		{
+			name:      "within the size limit",
+			maxTables: 3,
+			levels:    []int{first, first, first},
+			want:      "nil",
+		},
+		{
+			name:      "over the size limit",
+			maxTables: 1,
+			levels:    []int{first, first, first},
+			want:      fmt.Sprintf("%d->%d drop [a-a b-b]", first, first),
+		},
+		{
+			name:      "lower levels hold the oldest data",
+			maxTables: 2,
+			levels:    []int{last, first, first},
+			want:      fmt.Sprintf("%d->%d drop [a-a]", first, first),
+		},
+		{
+			name:   "over the age limit",
+			maxAge: time.Hour,
+			levels: []int{first, first, first},
+			old:    []bool{true, true, false},
+			want:   fmt.Sprintf("%d->%d drop [a-a b-b]", first, first),
+		},
+		{
+			name:   "first-level tables are ordered by age",
+			maxAge: time.Hour,
+			levels: []int{first, first},
+			old:    []bool{false, true},
+			want:   fmt.Sprintf("%d->%d drop [b-b]", first, first),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tree := newTestTree(t)
+			size := uint64(0)
+
+			for i, level := range test.levels {
+				table := addTable(t, tree, level, string(rune('a'+i)))
+				size = table.Size()
+
+				if test.old != nil && test.old[i] {
+					table.Header.Timestamp -= int64(2 * test.maxAge)
+				}
+			}
+
+			// the tables are all about the same size
+			maxSize := uint64(0)
+			if test.maxTables > 0 {
+				maxSize = uint64(test.maxTables)*size + size/2
+			}
+
+			setFIFOConfig(t, maxSize, test.maxAge)
+
+			if got := describeJob((&fifoPicker{}).PickCompaction(tree)); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func setFIFOConfig(t *testing.T, maxTableFilesSize uint64, maxAge time.Duration) {
+	config := configs.GetStorageEngineConfig()
+	previous := config.FIFOCompactionConfig
+
+	config.FIFOCompactionConfig.MaxTableFilesSize = maxTableFilesSize
+	config.FIFOCompactionConfig.MaxAgeMs = maxAge.Milliseconds()
+
+	t.Cleanup(func() { config.FIFOCompactionConfig = previous })
+}
@@ -0,0 +1,146 @@
+package backgroundprocess
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/sstable"
+)
+
+// leveledPicker keeps the levels below the first within target sizes growing
+// by LSMTreeConfig.LevelSizeMultiplier, moving one table at a time into the
+// next level. Reads look at one table per level, at the cost of rewriting
+// the data of a level about LevelSizeMultiplier times on its way down.
+type leveledPicker struct {
+	// per level, the end of the key range compacted last
+	cursors []string
+}
+
+func newLeveledPicker() *leveledPicker {
+	return &leveledPicker{
+		cursors: make([]string, configs.GetStorageEngineConfig().LSMTreeConfig.NumberOfSSTableLevels),
+	}
+}
+
+func (*leveledPicker) Name() string {
+	return "leveled"
+}
+
+// PickCompaction returns the compaction of the level most over its target, or
+// nil when every level is within it. The first level, whose tables overlap,
+// is over its target when it holds more than FirstLevelCompactionTrigger
+// tables and the levels below it when they hold more than their target bytes.
+// The last level has no target, but it is merged into itself if it ever holds
+// overlapping tables.
+func (picker *leveledPicker) PickCompaction(tree *lsmtree.LSMTree) *CompactionJob {
+	config := configs.GetStorageEngineConfig()
+
+	bestLevel, bestScore := -1, 1.0
+
+	for level := config.LSMTreeConfig.FirstLevel; level >= config.LSMTreeConfig.LastLevel; level-- {
+		tables := tree.GetSSTables(level)
+		score := 0.0
+
+		switch {
+		case level == config.LSMTreeConfig.FirstLevel:
+			score = float64(len(tables)) / float64(config.LSMTreeConfig.FirstLevelCompactionTrigger)
+		case level == config.LSMTreeConfig.LastLevel:
+			if len(tables) > 1 && !tree.HasDisjointTables(level) {
+				score = float64(len(tables))
+			}
+		default:
+			score = float64(levelSize(tables)) / float64(levelTargetSize(level))
+		}
+
+		if score > bestScore {
+			bestLevel, bestScore = level, score
+		}
+	}
+
+	if bestLevel < 0 {
+		return nil
+	}
+
+	return picker.newCompactionJob(tree, bestLevel)
+}
+
+// newCompactionJob picks the input tables of a compaction of level: all of
+// them when they may overlap, or else the next one after the last compacted
+// key of the level, so that compactions cycle through its key range.
+func (picker *leveledPicker) newCompactionJob(tree *lsmtree.LSMTree, level int) *CompactionJob {
+	config := configs.GetStorageEngineConfig()
+
+	tables := tree.GetSSTables(level)
+	job := &CompactionJob{InputLevel: level, OutputLevel: level - 1, Inputs: tables}
+
+	if level == config.LSMTreeConfig.LastLevel {
+		job.OutputLevel = level
+		return job
+	}
+
+	if level != config.LSMTreeConfig.FirstLevel && tree.HasDisjointTables(level) {
+		next := 0
+
+		for next < len(tables) && tables[next].KeyRange().Start < picker.cursors[level] {
+			next++
+		}
+
+		if next == len(tables) {
+			next = 0
+		}
+
+		job.Inputs = []*sstable.SSTable{tables[next]}
+		picker.cursors[level] = tables[next].KeyRange().Limit
+	}
+
+	job.Inputs = append(job.Inputs, overlappingTables(job.Inputs, tree.GetSSTables(job.OutputLevel))...)
+
+	return job
+}
+
+// overlappingTables returns the tables of the output level that a compaction
+// of inputs must include: those overlapping the inputs, and in turn the
+// tables overlapping those. Once they are merged, the output level holds
+// every version and range tombstone of the merged key range, so the outputs
+// neither overlap the tables left behind nor lose a tombstone still needed.
+func overlappingTables(inputs []*sstable.SSTable, outputLevel []*sstable.SSTable) []*sstable.SSTable {
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	keyRange := inputs[0].KeyRange()
+
+	for _, table := range inputs[1:] {
+		keyRange = keyRange.Union(table.KeyRange())
+	}
+
+	overlapping := make([]*sstable.SSTable, 0)
+	included := make([]bool, len(outputLevel))
+
+	for grown := true; grown; {
+		grown = false
+
+		for i, table := range outputLevel {
+			if !included[i] && table.KeyRange().Overlaps(keyRange) {
+				included[i], grown = true, true
+				overlapping = append(overlapping, table)
+				keyRange = keyRange.Union(table.KeyRange())
+			}
+		}
+	}
+
+	return overlapping
+}
+
+// levelTargetSize returns the bytes a level below the first may hold before
+// it is compacted into the next one.
+func levelTargetSize(level int) uint64 {
+	config := configs.GetStorageEngineConfig()
+
+	target := config.LSMTreeConfig.LevelBaseSize
+
+	for i := level; i < config.LSMTreeConfig.FirstLevel-1; i++ {
+		target *= config.LSMTreeConfig.LevelSizeMultiplier
+	}
+
+	return target
+}
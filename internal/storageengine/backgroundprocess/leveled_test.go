@@ -0,0 +1,115 @@
+package backgroundprocess
+
+import (
+	"fmt"
+	"pkvstore/internal/storageengine/configs"
+	"reflect"
+	"testing"
+)
+
+func TestPickCompaction(t *testing.T) {
+	config := configs.GetStorageEngineConfig()
+	first, last := config.LSMTreeConfig.FirstLevel, config.LSMTreeConfig.LastLevel
+
+	tests := []struct {
+		name          string
+		levelBaseSize uint64
+		tables        map[int][][]string // keys of the tables of each level
+		want          string             // the job, nil for none
+	}{
+		{
+			name:   "first level at the trigger",
+			tables: map[int][][]string{first: {{"a"}, {"b"}}},
+			want:   "nil",
+		},
+		{
+			name:   "first level over the trigger",
+			tables: map[int][][]string{first: {{"a", "c"}, {"b"}, {"e"}}, first - 1: {{"b", "d"}, {"f"}}},
+			want:   fmt.Sprintf("%d->%d [a-c b-b e-e b-d]", first, first-1),
+		},
+		{
+			name:          "level over its target",
+			levelBaseSize: 1,
+			tables: map[int][][]string{
+				first - 1: {{"a", "b"}, {"c", "d"}},
+				first - 2: {{"a"}, {"b", "c"}, {"e"}},
+			},
+			want: fmt.Sprintf("%d->%d [a-b a-a b-c]", first-1, first-2),
+		},
+		{
+			name:   "last level with overlapping tables",
+			tables: map[int][][]string{last: {{"a", "c"}, {"b"}}},
+			want:   fmt.Sprintf("%d->%d [a-c b-b]", last, last),
+		},
+		{
+			name:   "last level with disjoint tables",
+			tables: map[int][][]string{last: {{"a"}, {"b"}}},
+			want:   "nil",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			levelBaseSize := test.levelBaseSize
+			if levelBaseSize == 0 {
+				levelBaseSize = 1 << 20
+			}
+
+			setLeveledConfig(t, 2, levelBaseSize)
+
+			tree := newTestTree(t)
+
+			for level, tables := range test.tables {
+				for _, keys := range tables {
+					addTable(t, tree, level, keys...)
+				}
+			}
+
+			if got := describeJob(newLeveledPicker().PickCompaction(tree)); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+// Compactions of a level with disjoint tables take one table at a time,
+// cycling through the key range.
+func TestPickCompactionCyclesThroughLevel(t *testing.T) {
+	setLeveledConfig(t, 2, 1)
+
+	level := configs.GetStorageEngineConfig().LSMTreeConfig.FirstLevel - 1
+	tree, picker := newTestTree(t), newLeveledPicker()
+
+	for _, key := range []string{"c", "a", "b"} {
+		addTable(t, tree, level, key)
+	}
+
+	got := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		got = append(got, describeJob(picker.PickCompaction(tree)))
+	}
+
+	want := make([]string, 0)
+	for _, key := range []string{"a", "b", "c", "a"} {
+		want = append(want, fmt.Sprintf("%d->%d [%s-%s]", level, level-1, key, key))
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// setLeveledConfig sets the first-level compaction trigger and the target size
+// of the level below the first.
+func setLeveledConfig(t *testing.T, firstLevelCompactionTrigger int, levelBaseSize uint64) {
+	config := configs.GetStorageEngineConfig()
+	previousTrigger, previousSize := config.LSMTreeConfig.FirstLevelCompactionTrigger, config.LSMTreeConfig.LevelBaseSize
+
+	config.LSMTreeConfig.FirstLevelCompactionTrigger = firstLevelCompactionTrigger
+	config.LSMTreeConfig.LevelBaseSize = levelBaseSize
+
+	t.Cleanup(func() {
+		config.LSMTreeConfig.FirstLevelCompactionTrigger = previousTrigger
+		config.LSMTreeConfig.LevelBaseSize = previousSize
+	})
+}
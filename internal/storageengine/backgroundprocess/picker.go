@@ -0,0 +1,50 @@
+package backgroundprocess
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/sstable"
+)
+
+// CompactionJob merges tables of an input level with the tables of the
+// output level they overlap into new tables of the output level. The output
+// level must not be above the input level, and every table of the tree that
+// is newer than an input table and holds one of its keys must be an input or
+// above the output level. A job that drops its inputs deletes them instead.
+type CompactionJob struct {
+	InputLevel  int
+	OutputLevel int
+	Inputs      []*sstable.SSTable // of all the merged levels
+	Drop        bool               // delete the inputs without merging them
+}
+
+// CompactionPicker decides which tables the next compaction merges, and so
+// the balance between write, read and space amplification.
+type CompactionPicker interface {
+	Name() string
+
+	// PickCompaction returns the next compaction to run on tree, or nil when
+	// none is needed. It is only called from the compaction goroutine.
+	PickCompaction(tree *lsmtree.LSMTree) *CompactionJob
+}
+
+func newCompactionPicker(style configs.CompactionStyle) CompactionPicker {
+	switch style {
+	case configs.UniversalCompaction:
+		return &universalPicker{}
+	case configs.FIFOCompaction:
+		return &fifoPicker{}
+	default:
+		return newLeveledPicker()
+	}
+}
+
+func levelSize(tables []*sstable.SSTable) uint64 {
+	size := uint64(0)
+
+	for _, table := range tables {
+		size += table.Size()
+	}
+
+	return size
+}
@@ -0,0 +1,76 @@
+package backgroundprocess
+
+import (
+	"fmt"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/sstable"
+	"pkvstore/internal/storageengine/wal"
+	"testing"
+)
+
+// newTestTree opens an empty LSM tree in a temporary directory. Its
+// compactions are only picked, never run.
+func newTestTree(t *testing.T) *lsmtree.LSMTree {
+	config := configs.GetStorageEngineConfig()
+
+	directory := t.TempDir() + "/"
+	previousData, previousSSTables := config.DataDirectory, config.SSTableConfig.Directory
+	config.DataDirectory = directory
+	config.SSTableConfig.Directory = directory + "sstable/"
+
+	t.Cleanup(func() {
+		config.DataDirectory, config.SSTableConfig.Directory = previousData, previousSSTables
+	})
+
+	writeAheadLog, err := wal.NewWriteAheadLog(t.TempDir(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { writeAheadLog.Close() })
+
+	return lsmtree.NewLSMTree(memtable.NewMemTable(), writeAheadLog)
+}
+
+// addTable writes a table holding keys, which must be sorted, to level.
+func addTable(t *testing.T, tree *lsmtree.LSMTree, level int, keys ...string) *sstable.SSTable {
+	t.Helper()
+
+	table := sstable.OpenSSTable(uint8(level), 10)
+	table.FileNumber = tree.NewFileNumber()
+
+	for i, key := range keys {
+		table.AddEntry(sstable.NewSSTableEntry(key, uint64(i+1), "value", false))
+	}
+
+	if err := table.CompleteSSTableCreation().WriteToFile(configs.GetStorageEngineConfig().SSTableConfig.Directory); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.AddSSTable(table); err != nil {
+		t.Fatal(err)
+	}
+
+	return table
+}
+
+// describeJob describes a compaction job as input level->output level, drop
+// for a job deleting its inputs, and the key ranges of its inputs.
+func describeJob(job *CompactionJob) string {
+	if job == nil {
+		return "nil"
+	}
+
+	inputs := make([]string, 0, len(job.Inputs))
+
+	for _, table := range job.Inputs {
+		inputs = append(inputs, table.Blocks[0].Anchor.Key+"-"+table.LargestKey)
+	}
+
+	if job.Drop {
+		return fmt.Sprintf("%d->%d drop %v", job.InputLevel, job.OutputLevel, inputs)
+	}
+
+	return fmt.Sprintf("%d->%d %v", job.InputLevel, job.OutputLevel, inputs)
+}
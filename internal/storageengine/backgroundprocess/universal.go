@@ -0,0 +1,137 @@
+package backgroundprocess
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/sstable"
+)
+
+// universalPicker treats every first-level table and every level below it as
+// a sorted run and merges neighbouring runs of similar size, as in
+// size-tiered compaction. Data is rewritten about once per size tier it
+// passes, far less than with leveled compaction, while reads look at more
+// runs and old versions take more space until the runs holding them merge.
+type universalPicker struct{}
+
+func (*universalPicker) Name() string {
+	return "universal"
+}
+
+// sortedRun is a first-level table or all the tables of a level below it.
+type sortedRun struct {
+	level  int
+	tables []*sstable.SSTable
+	size   uint64
+}
+
+// PickCompaction returns a compaction once there are more sorted runs than
+// FirstLevelCompactionTrigger. It merges every run when the newer runs take
+// more than MaxSizeAmplificationPercent of the oldest one, or else the newest
+// group of at least MinMergeWidth runs whose every run is at most SizeRatio
+// percent larger than the runs newer than it together. Failing both, it
+// merges the newest MaxMergeWidth runs to bring their number down.
+func (picker *universalPicker) PickCompaction(tree *lsmtree.LSMTree) *CompactionJob {
+	config := configs.GetStorageEngineConfig()
+	universal := config.UniversalCompactionConfig
+
+	runs := sortedRuns(tree)
+
+	if len(runs) <= config.LSMTreeConfig.FirstLevelCompactionTrigger || len(runs) < 2 {
+		return nil
+	}
+
+	newer := uint64(0)
+
+	for _, run := range runs[:len(runs)-1] {
+		newer += run.size
+	}
+
+	if newer*100 >= runs[len(runs)-1].size*uint64(universal.MaxSizeAmplificationPercent) {
+		return newUniversalJob(runs, 0, len(runs))
+	}
+
+	for start := range runs {
+		accumulated, end := runs[start].size, start+1
+
+		for end < len(runs) && end-start < universal.MaxMergeWidth && runs[end].size*100 <= accumulated*uint64(100+universal.SizeRatio) {
+			accumulated += runs[end].size
+			end++
+		}
+
+		if end-start >= max(universal.MinMergeWidth, 2) && canMergeRuns(runs, end) {
+			return newUniversalJob(runs, start, end)
+		}
+	}
+
+	end := min(len(runs), max(universal.MaxMergeWidth, 2))
+
+	// the newest first-level tables can only be merged with the older ones
+	for !canMergeRuns(runs, end) {
+		end++
+	}
+
+	return newUniversalJob(runs, 0, end)
+}
+
+// sortedRuns returns the sorted runs of tree, newest first.
+func sortedRuns(tree *lsmtree.LSMTree) []sortedRun {
+	config := configs.GetStorageEngineConfig()
+
+	runs := make([]sortedRun, 0)
+	firstLevel := tree.GetSSTables(config.LSMTreeConfig.FirstLevel)
+
+	for i := len(firstLevel) - 1; i >= 0; i-- {
+		runs = append(runs, sortedRun{
+			level:  config.LSMTreeConfig.FirstLevel,
+			tables: firstLevel[i : i+1],
+			size:   firstLevel[i].Size(),
+		})
+	}
+
+	for level := config.LSMTreeConfig.FirstLevel - 1; level >= config.LSMTreeConfig.LastLevel; level-- {
+		if tables := tree.GetSSTables(level); len(tables) > 0 {
+			runs = append(runs, sortedRun{level: level, tables: tables, size: levelSize(tables)})
+		}
+	}
+
+	return runs
+}
+
+// canMergeRuns reports whether the runs before end can be merged on their
+// own. The output goes below the first level, so it must take the oldest
+// first-level table along with the newer ones.
+func canMergeRuns(runs []sortedRun, end int) bool {
+	config := configs.GetStorageEngineConfig()
+
+	return end == len(runs) || runs[end].level != config.LSMTreeConfig.FirstLevel
+}
+
+// newUniversalJob merges runs[start:end] into the level of the oldest of them
+// or, when that is a first-level table, into the level just above the next
+// older run. With no free level there, that run is merged too.
+func newUniversalJob(runs []sortedRun, start int, end int) *CompactionJob {
+	config := configs.GetStorageEngineConfig()
+
+	outputLevel := runs[end-1].level
+
+	if outputLevel == config.LSMTreeConfig.FirstLevel {
+		outputLevel = config.LSMTreeConfig.LastLevel
+
+		if end < len(runs) {
+			outputLevel = runs[end].level + 1
+		}
+
+		if outputLevel == config.LSMTreeConfig.FirstLevel {
+			end++
+			outputLevel = runs[end-1].level
+		}
+	}
+
+	job := &CompactionJob{InputLevel: runs[start].level, OutputLevel: outputLevel}
+
+	for _, run := range runs[start:end] {
+		job.Inputs = append(job.Inputs, run.tables...)
+	}
+
+	return job
+}
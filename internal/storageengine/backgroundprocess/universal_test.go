@@ -0,0 +1,119 @@
+package backgroundprocess
+
+import (
+	"fmt"
+	"pkvstore/internal/storageengine/configs"
+	"testing"
+)
+
+func TestUniversalPickCompaction(t *testing.T) {
+	config := configs.GetStorageEngineConfig()
+	first, last := config.LSMTreeConfig.FirstLevel, config.LSMTreeConfig.LastLevel
+
+	// a table of a few keys is mostly its filters; one of 600 keys is larger
+	// than three of them
+	large := numberedKeys("k", 600)
+
+	tests := []struct {
+		name          string
+		trigger       int
+		sizeRatio     int
+		maxMergeWidth int
+		tables        map[int][][]string // keys of the tables of each level, oldest first
+		want          string             // the job, nil for none
+	}{
+		{
+			name:    "runs at the trigger",
+			trigger: 2,
+			tables:  map[int][][]string{first: {{"a"}, {"b"}}},
+			want:    "nil",
+		},
+		{
+			name:    "newer runs outgrow the oldest",
+			trigger: 2,
+			tables:  map[int][][]string{first: {{"a"}, {"b"}}, last: {{"c"}}},
+			want:    fmt.Sprintf("%d->%d [b-b a-a c-c]", first, last),
+		},
+		{
+			name:          "runs of similar size",
+			trigger:       2,
+			sizeRatio:     1,
+			maxMergeWidth: 16,
+			tables:        map[int][][]string{first: {{"a"}, {"b"}, {"c"}}, last: {large}},
+			want:          fmt.Sprintf("%d->%d [c-c b-b a-a]", first, last+1),
+		},
+		{
+			// the newest two runs would leave an older first-level table
+			// above their output
+			name:          "runs of similar size beyond the merge width",
+			trigger:       2,
+			sizeRatio:     1,
+			maxMergeWidth: 2,
+			tables:        map[int][][]string{first: {{"a"}, {"b"}, {"c"}}, last: {large}},
+			want:          fmt.Sprintf("%d->%d [b-b a-a]", first, last+1),
+		},
+		{
+			name:      "no free level above the next run",
+			trigger:   1,
+			sizeRatio: 1,
+			tables:    map[int][][]string{first: {{"a"}, {"b"}}, first - 1: {large}},
+			want:      fmt.Sprintf("%d->%d [b-b a-a k000-k599]", first, first-1),
+		},
+		{
+			name:    "too many runs",
+			trigger: 2,
+			// each older table is larger than the newer ones together
+			tables: map[int][][]string{first: {numberedKeys("c", 40), numberedKeys("b", 20), {"a"}}},
+			want:   fmt.Sprintf("%d->%d [a-a b000-b019 c000-c039]", first, last),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			maxMergeWidth := test.maxMergeWidth
+			if maxMergeWidth == 0 {
+				maxMergeWidth = 2
+			}
+
+			setUniversalConfig(t, test.trigger, test.sizeRatio, maxMergeWidth)
+
+			tree := newTestTree(t)
+
+			for level, tables := range test.tables {
+				for _, keys := range tables {
+					addTable(t, tree, level, keys...)
+				}
+			}
+
+			if got := describeJob((&universalPicker{}).PickCompaction(tree)); got != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func numberedKeys(prefix string, count int) []string {
+	keys := make([]string, count)
+
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%03d", prefix, i)
+	}
+
+	return keys
+}
+
+// setUniversalConfig sets the sorted runs that start a compaction, the size
+// ratio and the most runs merged by it.
+func setUniversalConfig(t *testing.T, trigger int, sizeRatio int, maxMergeWidth int) {
+	config := configs.GetStorageEngineConfig()
+	previousTrigger, previous := config.LSMTreeConfig.FirstLevelCompactionTrigger, config.UniversalCompactionConfig
+
+	config.LSMTreeConfig.FirstLevelCompactionTrigger = trigger
+	config.UniversalCompactionConfig.SizeRatio = sizeRatio
+	config.UniversalCompactionConfig.MaxMergeWidth = maxMergeWidth
+
+	t.Cleanup(func() {
+		config.LSMTreeConfig.FirstLevelCompactionTrigger = previousTrigger
+		config.UniversalCompactionConfig = previous
+	})
+}
@@ -0,0 +1,331 @@
+package backgroundprocess
+
+import (
+	"log"
+	"pkvstore/internal/storageengine/compactionfilter"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"pkvstore/internal/storageengine/sstable"
+	"time"
+)
+
+// versionCompactor receives the versions of a compaction in internal key order
+// and writes the ones worth keeping to output tables of about
+// LSMTreeConfig.TargetFileSize, never splitting the versions of a key. Within a
+// snapshot stripe only the newest value or tombstone of a key survives, and the
+// merge operands above it are folded into it, or into one operand when the
+// stripe holds no value for them to apply to. Versions deleted by a range
+// tombstone of their own stripe are dropped as well, and expired values turn
+// into tombstones, or vanish when nothing older is left for them to hide. The
+// compaction filter, if any, sees the values no snapshot needs on their way
+// out.
+type versionCompactor struct {
+	outputs        []*sstable.SSTable
+	output         *sstable.SSTable // the last of outputs
+	numberEntries  uint             // versions in the input, to size the filters of the outputs
+	targetFileSize uint64
+
+	// range tombstones to write, split between the outputs in the end
+	keptTombstones []iterator.RangeTombstone
+
+	snapshots       []uint64
+	rangeTombstones []iterator.RangeTombstone
+	operator        mergeoperator.MergeOperator
+	filter          compactionfilter.CompactionFilter
+	filterContext   compactionfilter.Context
+	// no older versions exist below the output, so operands without a value
+	// apply to a missing key
+	bottommost bool
+	// Unix time in nanoseconds the expiry of values is judged at
+	now int64
+
+	started      bool
+	lastKey      string
+	lastSequence uint64
+	lastStripe   int
+	// the stripe's value or tombstone is written; its older versions are dropped
+	stripeDone bool
+	// merge operands of the stripe waiting for a value, newest first
+	operands []*sstable.SSTableEntry
+}
+
+// newVersionCompactor writes the numberEntries versions of a compaction to
+// the output level of filterContext. filter may be nil.
+func newVersionCompactor(numberEntries uint, snapshots []uint64, rangeTombstones []iterator.RangeTombstone, operator mergeoperator.MergeOperator, filter compactionfilter.CompactionFilter, filterContext compactionfilter.Context) *versionCompactor {
+	bottommost := filterContext.Bottommost
+
+	compactor := &versionCompactor{
+		numberEntries:   numberEntries,
+		targetFileSize:  configs.GetStorageEngineConfig().LSMTreeConfig.TargetFileSize,
+		snapshots:       snapshots,
+		rangeTombstones: rangeTombstones,
+		operator:        operator,
+		filter:          filter,
+		filterContext:   filterContext,
+		bottommost:      bottommost,
+		now:             time.Now().UnixNano(),
+	}
+
+	for _, tombstone := range rangeTombstones {
+		// with nothing older below the output and no snapshot older than the
+		// tombstone, every version it covers is dropped here
+		if bottommost && iterator.SnapshotStripe(snapshots, tombstone.Sequence) == 0 {
+			continue
+		}
+
+		compactor.keptTombstones = append(compactor.keptTombstones, tombstone)
+	}
+
+	compactor.openOutput()
+
+	return compactor
+}
+
+func (compactor *versionCompactor) openOutput() {
+	compactor.output = sstable.OpenSSTable(compactor.filterContext.OutputLevel, compactor.numberEntries)
+	compactor.outputs = append(compactor.outputs, compactor.output)
+}
+
+func (compactor *versionCompactor) add(entry *sstable.SSTableEntry) {
+	// a version held by several inputs, like a memtable being flushed and
+	// its table, counts once; copies arrive next to each other
+	if compactor.started && entry.Key == compactor.lastKey && entry.Sequence == compactor.lastSequence {
+		return
+	}
+
+	compactor.lastSequence = entry.Sequence
+
+	stripe := iterator.SnapshotStripe(compactor.snapshots, entry.Sequence)
+
+	if !compactor.started || entry.Key != compactor.lastKey || stripe != compactor.lastStripe {
+		newKey := !compactor.started || entry.Key != compactor.lastKey
+		compactor.finishStripe(newKey)
+
+		if newKey && compactor.targetFileSize > 0 && compactor.output.DataSize() >= compactor.targetFileSize {
+			compactor.openOutput()
+		}
+
+		compactor.started, compactor.lastKey, compactor.lastStripe, compactor.stripeDone = true, entry.Key, stripe, false
+	}
+
+	if compactor.stripeDone {
+		return
+	}
+
+	// deleted by a range tombstone that every reader seeing the entry also
+	// sees, like the older versions of the stripe
+	if compactor.coveredInStripe(entry, stripe) {
+		compactor.stripeDone = true
+
+		if len(compactor.operands) > 0 {
+			compactor.fullMerge(nil)
+		}
+
+		return
+	}
+
+	if entry.IsMerge {
+		compactor.operands = append(compactor.operands, entry)
+		return
+	}
+
+	compactor.stripeDone = true
+
+	if iterator.Expired(entry.ExpiresAt, compactor.now) {
+		compactor.expire(entry, stripe)
+		return
+	}
+
+	switch {
+	case len(compactor.operands) == 0 && entry.IsTombstone:
+		compactor.output.AddEntry(entry)
+	case len(compactor.operands) == 0:
+		compactor.addValue(entry)
+	case entry.ExpiresAt != 0:
+		// the operands apply to the value only until it expires, so reads
+		// have to merge them
+		compactor.keepOperands(entry)
+	default:
+		compactor.fullMerge(entry)
+	}
+}
+
+// expire replaces an expired value, and the operands waiting for it, which
+// now apply to a missing key.
+func (compactor *versionCompactor) expire(entry *sstable.SSTableEntry, stripe int) {
+	if len(compactor.operands) > 0 {
+		compactor.fullMerge(entry)
+		return
+	}
+
+	compactor.remove(entry, stripe)
+}
+
+// remove deletes the value of a stripe. A tombstone keeps hiding the older
+// versions of the key, unless there are none: the value is in the oldest
+// stripe and nothing lies below the output.
+func (compactor *versionCompactor) remove(entry *sstable.SSTableEntry, stripe int) {
+	if compactor.bottommost && stripe == 0 {
+		return
+	}
+
+	compactor.output.AddEntry(sstable.NewSSTableEntry(entry.Key, entry.Sequence, "", true))
+}
+
+// addValue writes the value of the current stripe, as the compaction filter
+// decides when no snapshot sees it.
+func (compactor *versionCompactor) addValue(entry *sstable.SSTableEntry) {
+	if compactor.filter == nil || compactor.lastStripe < len(compactor.snapshots) {
+		compactor.output.AddEntry(entry)
+		return
+	}
+
+	decision, value := compactor.filter.Filter(compactor.filterContext, entry.Key, entry.Value)
+
+	switch decision {
+	case compactionfilter.Remove:
+		compactor.remove(entry, compactor.lastStripe)
+	case compactionfilter.ChangeValue:
+		changed := *entry
+		changed.Value = value
+
+		compactor.output.AddEntry(&changed)
+	default:
+		compactor.output.AddEntry(entry)
+	}
+}
+
+func (compactor *versionCompactor) coveredInStripe(entry *sstable.SSTableEntry, stripe int) bool {
+	for i := range compactor.rangeTombstones {
+		tombstone := &compactor.rangeTombstones[i]
+
+		if tombstone.Sequence > entry.Sequence && tombstone.Covers(entry.Key) &&
+			iterator.SnapshotStripe(compactor.snapshots, tombstone.Sequence) == stripe {
+			return true
+		}
+	}
+
+	return false
+}
+
+// finish writes the operands still waiting at the end of the input and
+// returns the outputs. Each output gets the parts of the range tombstones from
+// its first key up to the first key of the next one, so the key ranges of the
+// outputs stay disjoint.
+func (compactor *versionCompactor) finish() []*sstable.SSTable {
+	compactor.finishStripe(true)
+
+	outputs := compactor.outputs
+
+	// only the last output can be empty, when the versions after a split
+	// were all dropped
+	if len(compactor.output.Blocks) == 0 && (len(outputs) > 1 || len(compactor.keptTombstones) == 0) {
+		outputs = outputs[:len(outputs)-1]
+	}
+
+	for i, output := range outputs {
+		start, end := "", ""
+
+		if i > 0 {
+			start = output.Blocks[0].Anchor.Key
+		}
+
+		if i+1 < len(outputs) {
+			end = outputs[i+1].Blocks[0].Anchor.Key
+		}
+
+		for _, tombstone := range compactor.keptTombstones {
+			if clipped, ok := tombstone.Clip(start, end); ok {
+				output.RangeTombstones = append(output.RangeTombstones, clipped)
+			}
+		}
+
+		output.CompleteSSTableCreation()
+	}
+
+	return outputs
+}
+
+// finishStripe writes the operands of a stripe that holds no value for them.
+// lastStripe tells whether the key has no older versions in the input.
+func (compactor *versionCompactor) finishStripe(lastStripe bool) {
+	if len(compactor.operands) == 0 {
+		return
+	}
+
+	if compactor.bottommost && lastStripe {
+		compactor.fullMerge(nil)
+		return
+	}
+
+	if compactor.operator == nil {
+		compactor.keepOperands(nil)
+		return
+	}
+
+	newest := compactor.operands[0]
+	combined := compactor.operands[len(compactor.operands)-1].Value
+
+	for i := len(compactor.operands) - 2; i >= 0; i-- {
+		var ok bool
+
+		if combined, ok = compactor.operator.PartialMerge(newest.Key, combined, compactor.operands[i].Value); !ok {
+			compactor.keepOperands(nil)
+			return
+		}
+	}
+
+	entry := sstable.NewSSTableEntry(newest.Key, newest.Sequence, combined, false)
+	entry.IsMerge = true
+
+	compactor.output.AddEntry(entry)
+	compactor.operands = nil
+}
+
+// fullMerge replaces the waiting operands and base, the value or tombstone
+// they apply to or nil for a missing key, with the value they add up to.
+func (compactor *versionCompactor) fullMerge(base *sstable.SSTableEntry) {
+	if compactor.operator == nil {
+		compactor.keepOperands(base)
+		return
+	}
+
+	existing, exists := "", false
+
+	if base != nil {
+		existing, exists = base.Value, !base.IsTombstone && !iterator.Expired(base.ExpiresAt, compactor.now)
+	}
+
+	operands := make([]string, len(compactor.operands))
+
+	for i, operand := range compactor.operands {
+		operands[i] = operand.Value
+	}
+
+	newest := compactor.operands[0]
+	value, err := compactor.operator.FullMerge(newest.Key, existing, exists, iterator.Reverse(operands))
+
+	if err != nil {
+		log.Println("Merging operands of", newest.Key+":", err)
+		compactor.keepOperands(base)
+		return
+	}
+
+	compactor.operands = nil
+	compactor.addValue(sstable.NewSSTableEntry(newest.Key, newest.Sequence, value, false))
+}
+
+// keepOperands writes the waiting operands and base unchanged, for reads to
+// merge.
+func (compactor *versionCompactor) keepOperands(base *sstable.SSTableEntry) {
+	for _, operand := range compactor.operands {
+		compactor.output.AddEntry(operand)
+	}
+
+	if base != nil {
+		compactor.output.AddEntry(base)
+	}
+
+	compactor.operands = nil
+}
@@ -0,0 +1,301 @@
+package backgroundprocess
+
+import (
+	"fmt"
+	"math"
+	"pkvstore/internal/storageengine/compactionfilter"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"pkvstore/internal/storageengine/sstable"
+	"reflect"
+	"testing"
+)
+
+func TestVersionCompactor(t *testing.T) {
+	tests := []struct {
+		name            string
+		versions        []*sstable.SSTableEntry
+		snapshots       []uint64
+		rangeTombstones []iterator.RangeTombstone
+		bottommost      bool
+		filter          compactionfilter.CompactionFilter
+		// compact without a merge operator
+		noOperator bool
+		want       []string
+		// the range tombstones kept with the output
+		wantRangeTombstones []iterator.RangeTombstone
+	}{
+		{
+			name:     "newest version wins",
+			versions: []*sstable.SSTableEntry{value("a", 3, "new"), value("a", 2, "old"), value("b", 1, "b")},
+			want:     []string{"a@3=new", "b@1=b"},
+		},
+		{
+			name:     "tombstone hides older versions",
+			versions: []*sstable.SSTableEntry{tombstone("a", 3), value("a", 2, "old")},
+			want:     []string{"a@3 deleted"},
+		},
+		{
+			name:      "versions visible to a snapshot are kept",
+			versions:  []*sstable.SSTableEntry{value("a", 5, "a5"), value("a", 4, "a4"), value("a", 2, "a2"), value("a", 1, "a1")},
+			snapshots: []uint64{2},
+			want:      []string{"a@5=a5", "a@2=a2"},
+		},
+		{
+			name:                "range tombstone drops older versions",
+			versions:            []*sstable.SSTableEntry{value("a", 5, "a5"), value("a", 3, "a3"), value("b", 2, "b"), value("c", 1, "c")},
+			rangeTombstones:     []iterator.RangeTombstone{{Start: "a", End: "c", Sequence: 4}},
+			want:                []string{"a@5=a5", "c@1=c"},
+			wantRangeTombstones: []iterator.RangeTombstone{{Start: "a", End: "c", Sequence: 4}},
+		},
+		{
+			name:                "range tombstone behind a snapshot",
+			versions:            []*sstable.SSTableEntry{value("a", 2, "old")},
+			snapshots:           []uint64{3},
+			rangeTombstones:     []iterator.RangeTombstone{{Start: "a", Sequence: 4}},
+			want:                []string{"a@2=old"},
+			wantRangeTombstones: []iterator.RangeTombstone{{Start: "a", Sequence: 4}},
+		},
+		{
+			name:            "range tombstone is dropped at the bottom",
+			versions:        []*sstable.SSTableEntry{value("a", 3, "a3"), value("b", 5, "b5")},
+			rangeTombstones: []iterator.RangeTombstone{{Start: "a", Sequence: 4}},
+			bottommost:      true,
+			want:            []string{"b@5=b5"},
+		},
+		{
+			name:     "operands are folded into the value",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 2, "2"), value("a", 1, "10")},
+			want:     []string{"a@3=13"},
+		},
+		{
+			name:     "operands over a tombstone",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "1"), tombstone("a", 2), value("a", 1, "10")},
+			want:     []string{"a@3=1"},
+		},
+		{
+			name:                "operands over a range tombstone",
+			versions:            []*sstable.SSTableEntry{operand("a", 3, "4"), value("a", 1, "10")},
+			rangeTombstones:     []iterator.RangeTombstone{{Start: "a", End: "b", Sequence: 2}},
+			want:                []string{"a@3=4"},
+			wantRangeTombstones: []iterator.RangeTombstone{{Start: "a", End: "b", Sequence: 2}},
+		},
+		{
+			name:     "operands without a value are combined",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 2, "2")},
+			want:     []string{"a@3+3"},
+		},
+		{
+			name:       "operands without a value at the bottom",
+			versions:   []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 2, "2")},
+			bottommost: true,
+			want:       []string{"a@3=3"},
+		},
+		{
+			name:      "operands do not cross a snapshot",
+			versions:  []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 2, "2"), value("a", 1, "10")},
+			snapshots: []uint64{2},
+			want:      []string{"a@3+1", "a@2=12"},
+		},
+		{
+			name:     "version held by two inputs counts once",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 3, "1"), value("a", 2, "10"), value("a", 2, "10"), value("b", 1, "b")},
+			want:     []string{"a@3=11", "b@1=b"},
+		},
+		{
+			name:       "operand held by two inputs at the bottom",
+			versions:   []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 3, "1")},
+			bottommost: true,
+			want:       []string{"a@3=1"},
+		},
+		{
+			name:     "expired value turns into a tombstone",
+			versions: []*sstable.SSTableEntry{expiring("a", 2, "gone", 1), value("a", 1, "old")},
+			want:     []string{"a@2 deleted"},
+		},
+		{
+			name:       "expired value at the bottom vanishes",
+			versions:   []*sstable.SSTableEntry{expiring("a", 2, "gone", 1), value("b", 1, "b")},
+			bottommost: true,
+			want:       []string{"b@1=b"},
+		},
+		{
+			name:     "operands over an expired value",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "4"), expiring("a", 2, "10", 1)},
+			want:     []string{"a@3=4"},
+		},
+		{
+			name:     "operands over an expiring value are kept",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "4"), expiring("a", 2, "10", math.MaxInt64)},
+			want:     []string{"a@3+4", "a@2=10"},
+		},
+		{
+			name:     "filter removes values",
+			versions: []*sstable.SSTableEntry{value("keep", 3, "k"), value("tmp/a", 2, "new"), value("tmp/a", 1, "old")},
+			filter:   compactionfilter.RemovePrefixes{Prefixes: []string{"tmp/"}},
+			want:     []string{"keep@3=k", "tmp/a@2 deleted"},
+		},
+		{
+			name:       "filter removes values at the bottom",
+			versions:   []*sstable.SSTableEntry{value("keep", 3, "k"), value("tmp/a", 2, "new")},
+			filter:     compactionfilter.RemovePrefixes{Prefixes: []string{"tmp/"}},
+			bottommost: true,
+			want:       []string{"keep@3=k"},
+		},
+		{
+			name:      "filter skips values a snapshot sees",
+			versions:  []*sstable.SSTableEntry{value("tmp/a", 3, "new"), value("tmp/a", 2, "old")},
+			snapshots: []uint64{2},
+			filter:    compactionfilter.RemovePrefixes{Prefixes: []string{"tmp/"}},
+			want:      []string{"tmp/a@3 deleted", "tmp/a@2=old"},
+		},
+		{
+			name:     "filter sees the merged value",
+			versions: []*sstable.SSTableEntry{operand("a", 3, "1"), value("a", 1, "10")},
+			filter:   appendFilter{suffix: "!"},
+			want:     []string{"a@3=11!"},
+		},
+		{
+			name:     "operands that fail to merge are kept",
+			versions: []*sstable.SSTableEntry{operand("a", 2, "1"), value("a", 1, "text")},
+			want:     []string{"a@2+1", "a@1=text"},
+		},
+		{
+			name:       "operands without a merge operator are kept",
+			versions:   []*sstable.SSTableEntry{operand("a", 3, "1"), operand("a", 2, "2"), value("a", 1, "10"), value("b", 4, "b")},
+			noOperator: true,
+			want:       []string{"a@3+1", "a@2+2", "a@1=10", "b@4=b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var operator mergeoperator.MergeOperator = mergeoperator.Int64Add{}
+			if test.noOperator {
+				operator = nil
+			}
+
+			compactor := newVersionCompactor(100, test.snapshots, test.rangeTombstones, operator, test.filter, compactionfilter.Context{Bottommost: test.bottommost})
+
+			for _, version := range test.versions {
+				compactor.add(version)
+			}
+			outputs := compactor.finish()
+
+			if len(outputs) > 1 {
+				t.Fatalf("got %d outputs, want at most 1", len(outputs))
+			}
+
+			got, gotRangeTombstones := []string{}, []iterator.RangeTombstone(nil)
+			if len(outputs) == 1 {
+				got, gotRangeTombstones = outputVersions(outputs[0]), outputs[0].RangeTombstones
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			if !reflect.DeepEqual(gotRangeTombstones, test.wantRangeTombstones) {
+				t.Errorf("range tombstones: got %v, want %v", gotRangeTombstones, test.wantRangeTombstones)
+			}
+		})
+	}
+}
+
+func TestVersionCompactorSplitsOutput(t *testing.T) {
+	config := configs.GetStorageEngineConfig()
+	previous := config.LSMTreeConfig.TargetFileSize
+	config.LSMTreeConfig.TargetFileSize = 3
+	t.Cleanup(func() { config.LSMTreeConfig.TargetFileSize = previous })
+
+	snapshots := []uint64{1}
+	rangeTombstones := []iterator.RangeTombstone{{Start: "b", Sequence: 2}}
+
+	compactor := newVersionCompactor(100, snapshots, rangeTombstones, mergeoperator.Int64Add{}, nil, compactionfilter.Context{})
+
+	// the versions of "a" outgrow the target but stay together
+	for _, version := range []*sstable.SSTableEntry{value("a", 3, "a3"), value("a", 1, "a1"), value("b", 4, "b4"), value("c", 5, "c5")} {
+		compactor.add(version)
+	}
+
+	outputs := compactor.finish()
+
+	got := make([][]string, 0)
+	gotRangeTombstones := make([][]iterator.RangeTombstone, 0)
+
+	for _, output := range outputs {
+		got = append(got, outputVersions(output))
+		gotRangeTombstones = append(gotRangeTombstones, output.RangeTombstones)
+	}
+
+	if want := [][]string{{"a@3=a3", "a@1=a1"}, {"b@4=b4"}, {"c@5=c5"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// each output covers the keys up to the next one
+	want := [][]iterator.RangeTombstone{nil, {{Start: "b", End: "c", Sequence: 2}}, {{Start: "c", Sequence: 2}}}
+	if !reflect.DeepEqual(gotRangeTombstones, want) {
+		t.Errorf("range tombstones: got %v, want %v", gotRangeTombstones, want)
+	}
+
+	if !sstable.SortByKeyRange(outputs) {
+		t.Error("outputs overlap")
+	}
+}
+
+// appendFilter appends suffix to every value.
+type appendFilter struct {
+	suffix string
+}
+
+func (appendFilter) Name() string {
+	return "append"
+}
+
+func (filter appendFilter) Filter(context compactionfilter.Context, key string, value string) (compactionfilter.Decision, string) {
+	return compactionfilter.ChangeValue, value + filter.suffix
+}
+
+func value(key string, sequence uint64, value string) *sstable.SSTableEntry {
+	return sstable.NewSSTableEntry(key, sequence, value, false)
+}
+
+func expiring(key string, sequence uint64, value string, expiresAt int64) *sstable.SSTableEntry {
+	entry := sstable.NewSSTableEntry(key, sequence, value, false)
+	entry.ExpiresAt = expiresAt
+
+	return entry
+}
+
+func tombstone(key string, sequence uint64) *sstable.SSTableEntry {
+	return sstable.NewSSTableEntry(key, sequence, "", true)
+}
+
+func operand(key string, sequence uint64, operand string) *sstable.SSTableEntry {
+	entry := sstable.NewSSTableEntry(key, sequence, operand, false)
+	entry.IsMerge = true
+
+	return entry
+}
+
+// outputVersions describes the entries of table as key@sequence followed by
+// =value, +operand or deleted.
+func outputVersions(table *sstable.SSTable) []string {
+	versions := make([]string, 0)
+
+	for _, block := range table.Blocks {
+		for _, entry := range block.Entries {
+			switch {
+			case entry.IsTombstone:
+				versions = append(versions, fmt.Sprint(entry.Key, "@", entry.Sequence, " deleted"))
+			case entry.IsMerge:
+				versions = append(versions, fmt.Sprint(entry.Key, "@", entry.Sequence, "+", entry.Value))
+			default:
+				versions = append(versions, fmt.Sprint(entry.Key, "@", entry.Sequence, "=", entry.Value))
+			}
+		}
+	}
+
+	return versions
+}
@@ -0,0 +1,48 @@
+package compactionfilter
+
+import "strings"
+
+// Decision tells compaction what to do with a value.
+type Decision int
+
+const (
+	Keep        Decision = iota // keep the value as it is
+	Remove                      // delete the key, as a tombstone would
+	ChangeValue                 // replace the value with the one returned
+)
+
+// Context describes the compaction a filter is called from.
+type Context struct {
+	InputLevel  uint8
+	OutputLevel uint8
+	Bottommost  bool // no older versions exist below the output level
+}
+
+// CompactionFilter is called for the newest value of each key compaction
+// writes out, after merge operands are folded into it. Values still visible to
+// a snapshot are not passed to it. Compactions run in the background, so a
+// filter must be safe to call from another goroutine.
+type CompactionFilter interface {
+	Name() string
+
+	Filter(context Context, key string, value string) (Decision, string)
+}
+
+// RemovePrefixes removes the keys under any of Prefixes.
+type RemovePrefixes struct {
+	Prefixes []string
+}
+
+func (RemovePrefixes) Name() string {
+	return "removeprefixes"
+}
+
+func (filter RemovePrefixes) Filter(context Context, key string, value string) (Decision, string) {
+	for _, prefix := range filter.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return Remove, ""
+		}
+	}
+
+	return Keep, ""
+}
@@ -6,14 +6,61 @@ import (
 
 const NUMBER_LEVELS = 7 // sstables: first level = 2^6, last level = 2^0
 
+// WALRecoveryMode decides how WAL replay treats a record that fails its checksum.
+type WALRecoveryMode int
+
+const (
+	StopAtFirstCorruption WALRecoveryMode = iota // replay up to the corrupted record and drop the rest
+	SkipCorruptedRecords                         // drop only the corrupted records
+	FailOnCorruption                             // refuse to start
+)
+
+// CompactionStyle decides which tables compactions merge.
+type CompactionStyle int
+
+const (
+	LeveledCompaction   CompactionStyle = iota // move one table at a time into the next level, for fewer tables to read
+	UniversalCompaction                        // merge whole sorted runs of similar size, for less rewriting of data
+	FIFOCompaction                             // never merge; delete the oldest tables, for time-series and log data
+)
+
+func (style CompactionStyle) String() string {
+	switch style {
+	case UniversalCompaction:
+		return "universal"
+	case FIFOCompaction:
+		return "fifo"
+	default:
+		return "leveled"
+	}
+}
+
+// WALSyncPolicy decides when WAL writes are fsynced to disk.
+type WALSyncPolicy int
+
+const (
+	NoSync         WALSyncPolicy = iota // leave flushing to the operating system
+	SyncEveryWrite                      // fsync once per group commit before acknowledging it
+	SyncInterval                        // fsync in the background every SyncIntervalMs
+)
+
 type StorageEngineConfig struct {
+	DataDirectory string
+
 	LSMTreeConfig struct {
 		NumberOfSSTableLevels int
 		FirstLevel            int
 		LastLevel             int
+
+		CompactionStyle             CompactionStyle
+		FirstLevelCompactionTrigger int    // first-level tables, which may overlap, or sorted runs for universal compaction, that start a compaction
+		LevelBaseSize               uint64 // target bytes of the level below the first
+		LevelSizeMultiplier         uint64 // growth of the target bytes from a level to the one below it
+		TargetFileSize              uint64 // compactions split their output into tables of about this size
 	}
 
 	SSTableConfig struct {
+		Directory                string
 		Version                  string
 		FirstLevel               int
 		FilterFalsePositive      float64 //TODO: dynamic
@@ -21,8 +68,43 @@ type StorageEngineConfig struct {
 		BlockFilterFalsePositive float64 //fixed
 	}
 
+	UniversalCompactionConfig struct {
+		SizeRatio                   int // percent a run may exceed the runs newer than it by and still be merged with them
+		MinMergeWidth               int // fewest runs merged by size ratio
+		MaxMergeWidth               int // most runs merged by size ratio
+		MaxSizeAmplificationPercent int // bytes of all newer runs, in percent of the oldest run, that start merging every run
+	}
+
+	FIFOCompactionConfig struct {
+		MaxTableFilesSize uint64 // bytes of all tables above which the oldest are deleted, 0 for no limit
+		MaxAgeMs          int64  // age at which a table is deleted, 0 for no limit
+	}
+
 	MemTableConfig struct {
-		MaxCapacity int
+		MaxSize               int // approximate bytes of keys, values and overhead before a switch
+		MaxImmutableMemtables int // full memtables allowed to wait for flush
+	}
+
+	WriteStallConfig struct {
+		SlowdownImmutableMemtables int // immutable memtables that start delaying writes
+		StopImmutableMemtables     int // immutable memtables that stop writes
+		SlowdownFirstLevelSSTables int // first-level tables that start delaying writes
+		StopFirstLevelSSTables     int // first-level tables that stop writes
+		DelayedWriteRate           int // bytes per second accepted while writes are delayed
+	}
+
+	WALConfig struct {
+		Directory        string
+		ArchiveDirectory string // flushed segments are moved here instead of deleted when set
+		RecoveryMode     WALRecoveryMode
+		SyncPolicy       WALSyncPolicy
+		SyncIntervalMs   int
+		MaxGroupCommit   int // most writes batched into one WAL write
+	}
+
+	TransactionConfig struct {
+		LockTimeoutMs int // default wait for a key lock in pessimistic transactions
+		IdleTimeoutMs int // transactions of the storage service left unused this long are rolled back, 0 for never
 	}
 }
 
@@ -30,17 +112,50 @@ func NewStorageEngineConfig() *StorageEngineConfig {
 
 	config := new(StorageEngineConfig)
 
+	config.DataDirectory = "./storage/"
+
 	config.LSMTreeConfig.NumberOfSSTableLevels = NUMBER_LEVELS // sstables: first level = 2^6, last level = 2^0
 	config.LSMTreeConfig.FirstLevel = config.LSMTreeConfig.NumberOfSSTableLevels - 1
 	config.LSMTreeConfig.LastLevel = 0
+	config.LSMTreeConfig.CompactionStyle = LeveledCompaction
+	config.LSMTreeConfig.FirstLevelCompactionTrigger = 1 << config.LSMTreeConfig.FirstLevel
+	config.LSMTreeConfig.LevelBaseSize = 64 << 10 // 256 << 20
+	config.LSMTreeConfig.LevelSizeMultiplier = 10
+	config.LSMTreeConfig.TargetFileSize = 16 << 10 // 64 << 20
 
+	config.SSTableConfig.Directory = config.DataDirectory + "sstable/"
 	config.SSTableConfig.Version = "1.0.0"
 	config.SSTableConfig.FirstLevel = config.LSMTreeConfig.FirstLevel
 	config.SSTableConfig.FilterFalsePositive = 0.1        // 1 in 10, 500MB, hash function 3 for 10^9 keys
 	config.SSTableConfig.BlockCapacity = 2048             //2048
 	config.SSTableConfig.BlockFilterFalsePositive = 0.001 // 1 in 1000, 3.59KiB, hash function 10
 
-	config.MemTableConfig.MaxCapacity = 2 //4096
+	config.UniversalCompactionConfig.SizeRatio = 1
+	config.UniversalCompactionConfig.MinMergeWidth = 2
+	config.UniversalCompactionConfig.MaxMergeWidth = 16
+	config.UniversalCompactionConfig.MaxSizeAmplificationPercent = 200
+
+	config.FIFOCompactionConfig.MaxTableFilesSize = 1 << 20 // 1 << 30
+	config.FIFOCompactionConfig.MaxAgeMs = 0
+
+	config.MemTableConfig.MaxSize = 256 // 4 << 20
+	config.MemTableConfig.MaxImmutableMemtables = 4
+
+	config.WriteStallConfig.SlowdownImmutableMemtables = config.MemTableConfig.MaxImmutableMemtables - 1
+	config.WriteStallConfig.StopImmutableMemtables = config.MemTableConfig.MaxImmutableMemtables
+	config.WriteStallConfig.SlowdownFirstLevelSSTables = 3 << (config.LSMTreeConfig.FirstLevel - 1) // compaction starts above 2^6
+	config.WriteStallConfig.StopFirstLevelSSTables = 1 << (config.LSMTreeConfig.FirstLevel + 1)
+	config.WriteStallConfig.DelayedWriteRate = 16 << 20
+
+	config.WALConfig.Directory = config.DataDirectory + "wal/"
+	config.WALConfig.ArchiveDirectory = ""
+	config.WALConfig.RecoveryMode = StopAtFirstCorruption
+	config.WALConfig.SyncPolicy = SyncEveryWrite
+	config.WALConfig.SyncIntervalMs = 100
+	config.WALConfig.MaxGroupCommit = 1024
+
+	config.TransactionConfig.LockTimeoutMs = 1000
+	config.TransactionConfig.IdleTimeoutMs = 60000
 
 	return config
 }
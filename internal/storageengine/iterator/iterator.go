@@ -0,0 +1,146 @@
+package iterator
+
+import (
+	"math"
+	"sort"
+)
+
+// MaxSequence is greater than the sequence number of every write, so reading
+// at it sees the newest version of each key.
+const MaxSequence uint64 = math.MaxUint64
+
+// Entry is one version of a key: the value written by the write with the
+// given sequence number, or a tombstone when that write deleted the key.
+type Entry struct {
+	Key         string
+	Sequence    uint64
+	Value       string
+	IsTombstone bool
+	IsMerge     bool  // Value is a merge operand
+	ExpiresAt   int64 // Unix time in nanoseconds after which the value reads as deleted, 0 for never
+}
+
+// Expired reports whether a version with the given expiry has expired at now,
+// both Unix times in nanoseconds. An expired version reads as a tombstone.
+func Expired(expiresAt int64, now int64) bool {
+	return expiresAt != 0 && expiresAt <= now
+}
+
+// CompareInternalKey orders versions by user key and then newest first. It
+// returns a negative number when (keyA, sequenceA) comes first, zero when
+// they are equal and a positive number otherwise.
+func CompareInternalKey(keyA string, sequenceA uint64, keyB string, sequenceB uint64) int {
+	if keyA != keyB {
+		if keyA < keyB {
+			return -1
+		}
+		return 1
+	}
+
+	if sequenceA > sequenceB {
+		return -1
+	}
+
+	if sequenceA < sequenceB {
+		return 1
+	}
+
+	return 0
+}
+
+// InternalIterator walks the versions held by one source in internal key
+// order, tombstones included.
+type InternalIterator interface {
+	// SeekToFirst positions the iterator at the smallest key.
+	SeekToFirst()
+	// Seek positions the iterator at the newest version of the first key
+	// greater than or equal to key.
+	Seek(key string)
+	Next()
+	Valid() bool
+	// Entry returns the current entry; only call it while Valid.
+	Entry() *Entry
+	// Close releases the source and reports any read error the iterator hit.
+	Close() error
+}
+
+// PrefixEnd returns the smallest key greater than every key starting with
+// prefix, or "" when there is none, so [prefix, PrefixEnd(prefix)) covers the
+// prefix.
+func PrefixEnd(prefix string) string {
+	end := []byte(prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+
+	return ""
+}
+
+// SnapshotStripe returns the index in snapshots, sorted ascending, of the
+// oldest snapshot that sees a version written at sequence, or len(snapshots)
+// when only reads of the newest data see it. Among the versions of a key in
+// the same stripe, readers only ever see the newest, so the others can be
+// dropped.
+func SnapshotStripe(snapshots []uint64, sequence uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i] >= sequence
+	})
+}
+
+// Reverse returns the values in reverse order, such as merge operands read
+// newest first in the oldest first order a MergeOperator takes them in.
+func Reverse(values []string) []string {
+	reversed := make([]string, len(values))
+
+	for i, value := range values {
+		reversed[len(values)-1-i] = value
+	}
+
+	return reversed
+}
+
+// RangeTombstone deletes every version of the keys in [Start, End) written
+// before Sequence. An empty End leaves the range unbounded above.
+type RangeTombstone struct {
+	Start    string
+	End      string
+	Sequence uint64
+}
+
+func (tombstone *RangeTombstone) Covers(key string) bool {
+	return tombstone.Start <= key && (tombstone.End == "" || key < tombstone.End)
+}
+
+// Clip returns the part of the tombstone inside [start, end), where an empty
+// end is unbounded, and reports whether there is one.
+func (tombstone RangeTombstone) Clip(start string, end string) (RangeTombstone, bool) {
+	clipped := tombstone
+	clipped.Start = max(tombstone.Start, start)
+
+	if end != "" && (clipped.End == "" || end < clipped.End) {
+		clipped.End = end
+	}
+
+	return clipped, clipped.End == "" || clipped.Start < clipped.End
+}
+
+// CoveringSequence returns the sequence number of the newest of tombstones
+// written no later than sequence that covers key, or 0 when none does. A
+// version of key older than it is deleted.
+func CoveringSequence(tombstones []RangeTombstone, key string, sequence uint64) uint64 {
+	covering := uint64(0)
+
+	for i := range tombstones {
+		tombstone := &tombstones[i]
+
+		if tombstone.Sequence <= sequence && tombstone.Sequence > covering && tombstone.Covers(key) {
+			covering = tombstone.Sequence
+		}
+	}
+
+	return covering
+}
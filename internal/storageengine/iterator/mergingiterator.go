@@ -0,0 +1,99 @@
+package iterator
+
+import (
+	"container/heap"
+	"errors"
+	"pkvstore/internal/core"
+)
+
+// MergingIterator merges sources into one stream in internal key order,
+// keeping every version of a key. A version held by several sources, like a
+// memtable being flushed and the table it is written to, comes out once.
+type MergingIterator struct {
+	// the position of a child is the SSTableID of its heap item
+	children []InternalIterator
+	frontier core.PriorityQueue
+}
+
+// NewMergingIterator merges children.
+func NewMergingIterator(children []InternalIterator) *MergingIterator {
+	return &MergingIterator{
+		children: children,
+		frontier: make(core.PriorityQueue, 0),
+	}
+}
+
+func (it *MergingIterator) SeekToFirst() {
+	for _, child := range it.children {
+		child.SeekToFirst()
+	}
+
+	it.rebuildFrontier()
+}
+
+func (it *MergingIterator) Seek(key string) {
+	for _, child := range it.children {
+		child.Seek(key)
+	}
+
+	it.rebuildFrontier()
+}
+
+func (it *MergingIterator) rebuildFrontier() {
+	it.frontier = make(core.PriorityQueue, 0, len(it.children))
+
+	for childID := range it.children {
+		it.pushChild(childID)
+	}
+}
+
+func (it *MergingIterator) pushChild(childID int) {
+	child := it.children[childID]
+
+	if !child.Valid() {
+		return
+	}
+
+	entry := child.Entry()
+
+	heap.Push(&it.frontier, &core.Item{
+		SortKey:   entry.Key,
+		Sequence:  entry.Sequence,
+		SSTableID: childID,
+	})
+}
+
+func (it *MergingIterator) Next() {
+	item := heap.Pop(&it.frontier).(*core.Item)
+
+	it.children[item.SSTableID].Next()
+	it.pushChild(item.SSTableID)
+
+	// copies of the same version sort next to each other
+	for it.Valid() && it.frontier[0].SortKey == item.SortKey && it.frontier[0].Sequence == item.Sequence {
+		duplicate := heap.Pop(&it.frontier).(*core.Item)
+
+		it.children[duplicate.SSTableID].Next()
+		it.pushChild(duplicate.SSTableID)
+	}
+}
+
+func (it *MergingIterator) Valid() bool {
+	return len(it.frontier) > 0
+}
+
+func (it *MergingIterator) Entry() *Entry {
+	return it.children[it.frontier[0].SSTableID].Entry()
+}
+
+func (it *MergingIterator) Close() error {
+	errs := make([]error, 0)
+
+	for _, child := range it.children {
+		if err := child.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,160 @@
+package iterator
+
+import (
+	"errors"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"time"
+)
+
+// Iterator returns the live keys of the store within [start, end) in key
+// order as of one sequence number. Writes after it, older versions of a key
+// and keys deleted by tombstones or range tombstones or expired are hidden,
+// and merge operands are combined with the value
+// they apply to. An empty end leaves the range unbounded above.
+type Iterator struct {
+	merged   *MergingIterator
+	start    string
+	end      string
+	sequence uint64
+	operator mergeoperator.MergeOperator
+	// the range tombstones of every source
+	rangeTombstones []RangeTombstone
+
+	// the current key and its value; every version of the key is already
+	// consumed from merged
+	key   string
+	value string
+	valid bool
+	err   error
+}
+
+// NewIterator reads children, along with the range tombstones of all of them,
+// as of sequence, combining merge operands with operator. The iterator starts
+// at the first key of the range.
+func NewIterator(children []InternalIterator, rangeTombstones []RangeTombstone, start string, end string, sequence uint64, operator mergeoperator.MergeOperator) *Iterator {
+	it := &Iterator{
+		merged:          NewMergingIterator(children),
+		start:           start,
+		end:             end,
+		sequence:        sequence,
+		operator:        operator,
+		rangeTombstones: rangeTombstones,
+	}
+
+	it.Seek(start)
+
+	return it
+}
+
+// Seek positions the iterator at the first live key at or after key, never
+// before the start of the range.
+func (it *Iterator) Seek(key string) {
+	if key < it.start {
+		key = it.start
+	}
+
+	it.merged.Seek(key)
+	it.findVisibleEntry()
+}
+
+func (it *Iterator) Next() {
+	it.findVisibleEntry()
+}
+
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+func (it *Iterator) Key() string {
+	return it.key
+}
+
+func (it *Iterator) Value() string {
+	return it.value
+}
+
+// Close releases the sources and reports any error hit while reading them or
+// while combining merge operands.
+func (it *Iterator) Close() error {
+	return errors.Join(it.merged.Close(), it.err)
+}
+
+// findVisibleEntry moves to the first key, after the versions already
+// consumed, that is not deleted at the iterator's sequence and takes its value
+// from the newest version no later than that sequence. A key whose operands
+// fail to merge is skipped and the error is reported by Close.
+func (it *Iterator) findVisibleEntry() {
+	it.valid = false
+
+	for it.merged.Valid() && (it.end == "" || it.merged.Entry().Key < it.end) {
+		entry := it.merged.Entry()
+
+		if entry.Sequence > it.sequence {
+			it.merged.Next()
+			continue
+		}
+
+		value, exists, err := it.resolve()
+
+		if err != nil {
+			it.err = err
+			continue
+		}
+
+		if exists {
+			it.key, it.value, it.valid = entry.Key, value, true
+			return
+		}
+	}
+}
+
+// resolve consumes every version of the current key, the first of which is
+// visible, and returns its value, if the key exists.
+func (it *Iterator) resolve() (string, bool, error) {
+	first := it.merged.Entry()
+	key := first.Key
+	// versions older than this are deleted by a range tombstone
+	covering := CoveringSequence(it.rangeTombstones, key, it.sequence)
+	now := time.Now().UnixNano()
+
+	defer func() {
+		for it.merged.Valid() && it.merged.Entry().Key == key {
+			it.merged.Next()
+		}
+	}()
+
+	if first.Sequence < covering {
+		return "", false, nil
+	}
+
+	if !first.IsMerge {
+		return first.Value, !first.IsTombstone && !Expired(first.ExpiresAt, now), nil
+	}
+
+	// operands newest first, then the value they apply to, if any
+	operands := []string{first.Value}
+	existing, exists := "", false
+
+	for it.merged.Next(); it.merged.Valid() && it.merged.Entry().Key == key; it.merged.Next() {
+		entry := it.merged.Entry()
+
+		if entry.Sequence < covering {
+			break
+		}
+
+		if !entry.IsMerge {
+			existing, exists = entry.Value, !entry.IsTombstone && !Expired(entry.ExpiresAt, now)
+			break
+		}
+
+		operands = append(operands, entry.Value)
+	}
+
+	if it.operator == nil {
+		return "", false, mergeoperator.ErrNoMergeOperator
+	}
+
+	value, err := it.operator.FullMerge(key, existing, exists, Reverse(operands))
+
+	return value, err == nil, err
+}
@@ -0,0 +1,333 @@
+package iterator
+
+import (
+	"errors"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// sliceIterator walks entries held in internal key order.
+type sliceIterator struct {
+	entries  []*Entry
+	position int
+	closed   bool
+}
+
+func newSliceIterator(entries ...*Entry) *sliceIterator {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return CompareInternalKey(entries[i].Key, entries[i].Sequence, entries[j].Key, entries[j].Sequence) < 0
+	})
+
+	return &sliceIterator{entries: entries}
+}
+
+func (it *sliceIterator) SeekToFirst() {
+	it.position = 0
+}
+
+func (it *sliceIterator) Seek(key string) {
+	it.position = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].Key >= key })
+}
+
+func (it *sliceIterator) Next() {
+	it.position++
+}
+
+func (it *sliceIterator) Valid() bool {
+	return it.position < len(it.entries)
+}
+
+func (it *sliceIterator) Entry() *Entry {
+	return it.entries[it.position]
+}
+
+func (it *sliceIterator) Close() error {
+	it.closed = true
+	return nil
+}
+
+func value(key string, sequence uint64, value string) *Entry {
+	return &Entry{Key: key, Sequence: sequence, Value: value}
+}
+
+func tombstone(key string, sequence uint64) *Entry {
+	return &Entry{Key: key, Sequence: sequence, IsTombstone: true}
+}
+
+func operand(key string, sequence uint64, operand string) *Entry {
+	return &Entry{Key: key, Sequence: sequence, Value: operand, IsMerge: true}
+}
+
+func TestIterator(t *testing.T) {
+	tests := []struct {
+		name            string
+		sources         [][]*Entry
+		rangeTombstones []RangeTombstone
+		start           string
+		end             string
+		sequence        uint64
+		want            []string // alternating keys and values
+		wantErr         error
+	}{
+		{
+			name:    "newest version wins across sources",
+			sources: [][]*Entry{{value("a", 1, "old"), value("b", 2, "b")}, {value("a", 3, "new")}},
+			want:    []string{"a", "new", "b", "b"},
+		},
+		{
+			name:    "newest version wins within a source",
+			sources: [][]*Entry{{value("a", 1, "old"), value("a", 3, "new")}, {value("a", 2, "middle")}},
+			want:    []string{"a", "new"},
+		},
+		{
+			name:    "tombstone hides older versions",
+			sources: [][]*Entry{{value("a", 1, "a"), value("b", 2, "b")}, {tombstone("a", 3)}},
+			want:    []string{"b", "b"},
+		},
+		{
+			name:    "value written after a tombstone",
+			sources: [][]*Entry{{tombstone("a", 1)}, {value("a", 2, "again")}},
+			want:    []string{"a", "again"},
+		},
+		{
+			name:            "range tombstone hides older versions only",
+			sources:         [][]*Entry{{value("a", 1, "a"), value("b", 2, "b"), value("c", 5, "c"), value("d", 3, "d")}},
+			rangeTombstones: []RangeTombstone{{Start: "a", End: "d", Sequence: 4}},
+			want:            []string{"c", "c", "d", "d"},
+		},
+		{
+			name:            "range tombstone newer than the sequence",
+			sources:         [][]*Entry{{value("a", 1, "a")}},
+			rangeTombstones: []RangeTombstone{{Start: "a", Sequence: 4}},
+			sequence:        3,
+			want:            []string{"a", "a"},
+		},
+		{
+			name:     "versions newer than the sequence are hidden",
+			sources:  [][]*Entry{{value("a", 1, "old"), value("a", 5, "new"), value("b", 6, "b")}},
+			sequence: 4,
+			want:     []string{"a", "old"},
+		},
+		{
+			name:     "tombstone newer than the sequence",
+			sources:  [][]*Entry{{value("a", 1, "a"), tombstone("a", 5)}},
+			sequence: 4,
+			want:     []string{"a", "a"},
+		},
+		{
+			name:    "expired value",
+			sources: [][]*Entry{{{Key: "a", Sequence: 2, Value: "gone", ExpiresAt: 1}, value("a", 1, "old"), value("b", 3, "b")}},
+			want:    []string{"b", "b"},
+		},
+		{
+			name:    "merge operands over an expired value",
+			sources: [][]*Entry{{{Key: "a", Sequence: 1, Value: "10", ExpiresAt: 1}, operand("a", 2, "4")}},
+			want:    []string{"a", "4"},
+		},
+		{
+			name:    "bounds",
+			sources: [][]*Entry{{value("a", 1, "a"), value("b", 2, "b"), value("c", 3, "c")}},
+			start:   "b",
+			end:     "c",
+			want:    []string{"b", "b"},
+		},
+		{
+			name:    "tombstones at the end of the range",
+			sources: [][]*Entry{{value("a", 1, "a"), tombstone("b", 2), tombstone("c", 3)}},
+			want:    []string{"a", "a"},
+		},
+		{
+			name:    "merge operands over a value",
+			sources: [][]*Entry{{value("a", 1, "10"), operand("a", 2, "5")}, {operand("a", 3, "1")}},
+			want:    []string{"a", "16"},
+		},
+		{
+			name:    "merge operands without a value",
+			sources: [][]*Entry{{operand("a", 1, "2"), operand("a", 2, "3")}},
+			want:    []string{"a", "5"},
+		},
+		{
+			name:    "merge operands over a tombstone",
+			sources: [][]*Entry{{value("a", 1, "10"), tombstone("a", 2), operand("a", 3, "4")}},
+			want:    []string{"a", "4"},
+		},
+		{
+			name:            "merge operands over a range tombstone",
+			sources:         [][]*Entry{{value("a", 1, "10"), operand("a", 3, "4")}},
+			rangeTombstones: []RangeTombstone{{Start: "a", End: "b", Sequence: 2}},
+			want:            []string{"a", "4"},
+		},
+		{
+			name:     "merge operands newer than the sequence",
+			sources:  [][]*Entry{{value("a", 1, "10"), operand("a", 2, "5"), operand("a", 3, "1")}},
+			sequence: 2,
+			want:     []string{"a", "15"},
+		},
+		{
+			name:    "value written after merge operands",
+			sources: [][]*Entry{{operand("a", 1, "5"), value("a", 2, "7")}},
+			want:    []string{"a", "7"},
+		},
+		{
+			name:    "operands that fail to merge skip the key",
+			sources: [][]*Entry{{value("a", 1, "text"), operand("a", 2, "1"), value("b", 3, "b")}},
+			want:    []string{"b", "b"},
+			wantErr: strconv.ErrSyntax,
+		},
+		{
+			name:    "operand held by two sources counts once",
+			sources: [][]*Entry{{value("a", 1, "10"), operand("a", 2, "5")}, {operand("a", 2, "5")}},
+			want:    []string{"a", "15"},
+		},
+		{
+			name:    "no sources",
+			sources: [][]*Entry{},
+			want:    []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources := make([]InternalIterator, len(test.sources))
+			for i, entries := range test.sources {
+				sources[i] = newSliceIterator(entries...)
+			}
+
+			sequence := test.sequence
+			if sequence == 0 {
+				sequence = MaxSequence
+			}
+
+			it := NewIterator(sources, test.rangeTombstones, test.start, test.end, sequence, mergeoperator.Int64Add{})
+
+			got := make([]string, 0)
+			for ; it.Valid(); it.Next() {
+				got = append(got, it.Key(), it.Value())
+			}
+
+			if err := it.Close(); !errors.Is(err, test.wantErr) || (err != nil) != (test.wantErr != nil) {
+				t.Errorf("Close: got %v, want %v", err, test.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+
+			for i, source := range sources {
+				if !source.(*sliceIterator).closed {
+					t.Errorf("source %d left open", i)
+				}
+			}
+		})
+	}
+}
+
+func TestIteratorSeek(t *testing.T) {
+	source := newSliceIterator(value("a", 1, "a"), tombstone("b", 2), value("c", 3, "c"), value("d", 4, "d"))
+	it := NewIterator([]InternalIterator{source}, nil, "b", "", MaxSequence, nil)
+
+	tests := []struct {
+		seek string
+		want string // "" when the iterator is exhausted
+	}{
+		{seek: "a", want: "c"},
+		{seek: "b", want: "c"},
+		{seek: "cc", want: "d"},
+		{seek: "e", want: ""},
+	}
+
+	for _, test := range tests {
+		it.Seek(test.seek)
+
+		got := ""
+		if it.Valid() {
+			got = it.Key()
+		}
+
+		if got != test.want {
+			t.Errorf("Seek(%q): at %q, want %q", test.seek, got, test.want)
+		}
+	}
+}
+
+func TestIteratorWithoutMergeOperator(t *testing.T) {
+	it := NewIterator([]InternalIterator{newSliceIterator(operand("a", 1, "1"))}, nil, "", "", MaxSequence, nil)
+
+	if it.Valid() {
+		t.Errorf("got key %q", it.Key())
+	}
+
+	if err := it.Close(); !errors.Is(err, mergeoperator.ErrNoMergeOperator) {
+		t.Errorf("Close: got %v, want %v", err, mergeoperator.ErrNoMergeOperator)
+	}
+}
+
+// A version held by two sources, as by a memtable being flushed and its
+// table, comes out once.
+func TestMergingIteratorKeepsEveryVersion(t *testing.T) {
+	it := NewMergingIterator([]InternalIterator{
+		newSliceIterator(value("a", 1, "a1"), value("b", 4, "b4")),
+		newSliceIterator(value("a", 3, "a3")),
+		newSliceIterator(tombstone("a", 2), value("b", 4, "b4")),
+	})
+
+	want := []string{"a@3", "a@2", "a@1", "b@4"}
+	got := make([]string, 0)
+
+	for it.SeekToFirst(); it.Valid(); it.Next() {
+		got = append(got, it.Entry().Key+"@"+strconv.FormatUint(it.Entry().Sequence, 10))
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{prefix: "abc", want: "abd"},
+		{prefix: "ab\xff", want: "ac"},
+		{prefix: "\xff\xff", want: ""},
+		{prefix: "", want: ""},
+	}
+
+	for _, test := range tests {
+		if got := PrefixEnd(test.prefix); got != test.want {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestCoveringSequence(t *testing.T) {
+	tombstones := []RangeTombstone{
+		{Start: "b", End: "d", Sequence: 3},
+		{Start: "c", End: "", Sequence: 5},
+		{Start: "a", End: "c", Sequence: 7},
+	}
+
+	tests := []struct {
+		key      string
+		sequence uint64
+		want     uint64
+	}{
+		{key: "a", sequence: MaxSequence, want: 7},
+		{key: "b", sequence: MaxSequence, want: 7},
+		{key: "b", sequence: 6, want: 3},
+		{key: "c", sequence: MaxSequence, want: 5},
+		{key: "d", sequence: MaxSequence, want: 5},
+		{key: "d", sequence: 4, want: 0},
+		{key: "a", sequence: 6, want: 0},
+	}
+
+	for _, test := range tests {
+		if got := CoveringSequence(tombstones, test.key, test.sequence); got != test.want {
+			t.Errorf("CoveringSequence(%q, %d) = %d, want %d", test.key, test.sequence, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package lsmtree
+
+import (
+	"errors"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"pkvstore/internal/storageengine/wal"
+)
+
+var ErrConditionNotMet = errors.New("condition not met: the key does not hold the expected value")
+
+// writeCondition holds when key exists with value, or when exists is false,
+// when key does not exist at all.
+type writeCondition struct {
+	key    string
+	exists bool
+	value  string
+}
+
+// CompareAndSwap sets key to newValue if it currently holds expectedValue and
+// reports whether it did.
+func (lsm *LSMTree) CompareAndSwap(key string, expectedValue string, newValue string) (bool, error) {
+	return lsm.writeIf(&writeCondition{key: key, exists: true, value: expectedValue},
+		&wal.LogEntry{Operation: wal.InsertOperation, Key: key, Value: newValue})
+}
+
+// PutIfAbsent sets key to value if it does not exist and reports whether it
+// did.
+func (lsm *LSMTree) PutIfAbsent(key string, value string) (bool, error) {
+	return lsm.writeIf(&writeCondition{key: key},
+		&wal.LogEntry{Operation: wal.InsertOperation, Key: key, Value: value})
+}
+
+// DeleteIfEquals deletes key if it currently holds expectedValue and reports
+// whether it did.
+func (lsm *LSMTree) DeleteIfEquals(key string, expectedValue string) (bool, error) {
+	return lsm.writeIf(&writeCondition{key: key, exists: true, value: expectedValue},
+		&wal.LogEntry{Operation: wal.DeleteOperation, Key: key})
+}
+
+// writeIf writes entry if the condition holds. The condition is checked by
+// the group commit under the write mutex, so no other write to the key can
+// come between the check and the write.
+func (lsm *LSMTree) writeIf(condition *writeCondition, entry *wal.LogEntry) (bool, error) {
+	err := lsm.submit(&writeRequest{
+		entries:   []*wal.LogEntry{entry},
+		condition: condition,
+	}, len(entry.Key)+len(entry.Value))
+
+	if err == ErrConditionNotMet {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// checkCondition fails the request when its condition does not hold for the
+// newest write of the key, in the tree or earlier in the group.
+func (lsm *LSMTree) checkCondition(request *writeRequest, pending *pendingWrites) error {
+	condition := request.condition
+
+	if condition == nil {
+		return nil
+	}
+
+	value, exists, err := lsm.pendingValue(condition.key, pending.forKey(condition.key))
+
+	if err != nil {
+		return err
+	}
+
+	if exists != condition.exists || exists && value != condition.value {
+		return ErrConditionNotMet
+	}
+
+	return nil
+}
+
+// pendingValue returns the value of key once its writes pending in the group,
+// oldest first, are applied on top of the tree.
+func (lsm *LSMTree) pendingValue(key string, writes []*wal.LogEntry) (string, bool, error) {
+	// merge operands written after the newest pending value or delete,
+	// newest first
+	operands := make([]string, 0)
+	value, exists, found := "", false, false
+
+	for i := len(writes) - 1; i >= 0 && !found; i-- {
+		switch writes[i].Operation {
+		case wal.MergeOperation:
+			operands = append(operands, writes[i].Value)
+		case wal.DeleteOperation, wal.DeleteRangeOperation:
+			found = true
+		default:
+			value, exists, found = writes[i].Value, true, true
+		}
+	}
+
+	if !found {
+		result, err := lsm.Get(key, nil)
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if result.Status == models.Found {
+			value, exists = result.Value, true
+		}
+	}
+
+	if len(operands) == 0 {
+		return value, exists, nil
+	}
+
+	operator := lsm.MergeOperator()
+
+	if operator == nil {
+		return "", false, mergeoperator.ErrNoMergeOperator
+	}
+
+	value, err := operator.FullMerge(key, value, exists, iterator.Reverse(operands))
+
+	return value, err == nil, err
+}
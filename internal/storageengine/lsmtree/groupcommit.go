@@ -0,0 +1,224 @@
+package lsmtree
+
+import (
+	"log"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/wal"
+)
+
+// writeRequest is a single mutation or an atomic batch of them waiting for
+// its group commit.
+type writeRequest struct {
+	entries []*wal.LogEntry
+	done    chan error
+
+	// when set, the request fails unless none of these keys has a version
+	// newer than conflictSequence at commit time
+	conflictKeys     []string
+	conflictSequence uint64
+	// when set, the request is skipped with ErrConditionNotMet unless the
+	// condition holds at commit time
+	condition *writeCondition
+	err       error
+}
+
+// write hands the entries of size bytes to the group commit loop and blocks
+// until the group they joined is logged to the WAL and applied to the
+// memtable. Writes are slowed down or held back first while flushes and
+// compactions fall behind.
+func (lsm *LSMTree) write(entries []*wal.LogEntry, size int) error {
+	return lsm.submit(&writeRequest{entries: entries}, size)
+}
+
+func (lsm *LSMTree) submit(request *writeRequest, size int) error {
+	lsm.waitForWriteStall(size)
+
+	request.done = make(chan error, 1)
+
+	lsm.writeRequests <- request
+
+	return <-request.done
+}
+
+// listenWriteRequests batches every write request that is waiting when a group
+// starts, so concurrent writers share one WAL write and one fsync.
+func (lsm *LSMTree) listenWriteRequests() {
+	config := configs.GetStorageEngineConfig()
+
+	for request := range lsm.writeRequests {
+		group := []*writeRequest{request}
+
+	collect:
+		for len(group) < config.WALConfig.MaxGroupCommit {
+			select {
+			case next := <-lsm.writeRequests:
+				group = append(group, next)
+			default:
+				break collect
+			}
+		}
+
+		err := lsm.commit(group)
+
+		for _, request := range group {
+			if request.err != nil {
+				request.done <- request.err
+				continue
+			}
+
+			request.done <- err
+		}
+	}
+}
+
+// commit logs the group to the WAL, which numbers its writes, applies it to
+// the memtable in the same order and then switches the memtable if it is
+// full. Every request is one WAL record. Holding the write mutex keeps a
+// memtable switch from splitting the group across WAL segments.
+func (lsm *LSMTree) commit(group []*writeRequest) error {
+	lsm.writeMutex.Lock()
+	defer lsm.writeMutex.Unlock()
+
+	pending := newPendingWrites()
+	batches := make([][]*wal.LogEntry, 0, len(group))
+
+	for _, request := range group {
+		if request.err = lsm.checkConflicts(request, pending); request.err != nil {
+			continue
+		}
+
+		if request.err = lsm.checkCondition(request, pending); request.err != nil {
+			continue
+		}
+
+		pending.add(request.entries)
+		batches = append(batches, request.entries)
+	}
+
+	if err := lsm.wal.WriteBatches(batches); err != nil {
+		return err
+	}
+
+	for _, batch := range batches {
+		for _, entry := range batch {
+			switch entry.Operation {
+			case wal.InsertOperation, wal.UpdateOperation:
+				lsm.MemTable.PutWithExpiry(entry.Key, entry.Value, entry.ExpiresAt, entry.Sequence)
+			case wal.DeleteOperation:
+				lsm.MemTable.Delete(entry.Key, entry.Sequence)
+			case wal.MergeOperation:
+				lsm.MemTable.Merge(entry.Key, entry.Value, entry.Sequence)
+			case wal.DeleteRangeOperation:
+				lsm.MemTable.DeleteRange(entry.Key, entry.Value, entry.Sequence)
+			}
+		}
+	}
+
+	// reads see the group only once all of it is in the memtable
+	lsm.lastSequence.Store(lsm.wal.LastSequence())
+
+	// the next group goes to a fresh memtable once this one is full, so the
+	// memtable outgrows MemTableConfig.MaxSize by at most one group
+	switched, err := lsm.switchFullMemtable()
+
+	if err != nil {
+		log.Println("Rotating WAL segment:", err)
+	}
+
+	if switched {
+		// each flush event drains every immutable memtable, so when the
+		// channel is full a pending event flushes this one too
+		select {
+		case lsm.sharedChannel.FlushMemtableEvent <- 1:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// checkConflicts fails the request when one of its conflict keys changed
+// after its conflict sequence, in the tree or earlier in the group.
+func (lsm *LSMTree) checkConflicts(request *writeRequest, pending *pendingWrites) error {
+	for _, key := range request.conflictKeys {
+		if len(pending.forKey(key)) > 0 {
+			return ErrTransactionConflict
+		}
+
+		result, err := lsm.Get(key, nil)
+
+		if err != nil {
+			return err
+		}
+
+		if result.Sequence > request.conflictSequence {
+			return ErrTransactionConflict
+		}
+	}
+
+	return nil
+}
+
+// pendingWrites are the writes of the earlier requests of a group, which are
+// not in the memtable yet.
+type pendingWrites struct {
+	count        int
+	keys         map[string][]pendingWrite
+	rangeDeletes []pendingWrite
+}
+
+// pendingWrite is a write and its position in the group.
+type pendingWrite struct {
+	entry *wal.LogEntry
+	order int
+}
+
+func newPendingWrites() *pendingWrites {
+	return &pendingWrites{
+		keys: make(map[string][]pendingWrite),
+	}
+}
+
+func (pending *pendingWrites) add(entries []*wal.LogEntry) {
+	for _, entry := range entries {
+		write := pendingWrite{entry: entry, order: pending.count}
+		pending.count++
+
+		if entry.Operation == wal.DeleteRangeOperation {
+			pending.rangeDeletes = append(pending.rangeDeletes, write)
+			continue
+		}
+
+		pending.keys[entry.Key] = append(pending.keys[entry.Key], write)
+	}
+}
+
+// forKey returns the pending writes of key, including the range deletes
+// covering it, oldest first.
+func (pending *pendingWrites) forKey(key string) []*wal.LogEntry {
+	writes := pending.keys[key]
+	entries := make([]*wal.LogEntry, 0, len(writes))
+
+	next := 0
+
+	for _, rangeDelete := range pending.rangeDeletes {
+		tombstone := iterator.RangeTombstone{Start: rangeDelete.entry.Key, End: rangeDelete.entry.Value}
+
+		if !tombstone.Covers(key) {
+			continue
+		}
+
+		for ; next < len(writes) && writes[next].order < rangeDelete.order; next++ {
+			entries = append(entries, writes[next].entry)
+		}
+
+		entries = append(entries, rangeDelete.entry)
+	}
+
+	for ; next < len(writes); next++ {
+		entries = append(entries, writes[next].entry)
+	}
+
+	return entries
+}
@@ -0,0 +1,58 @@
+package lsmtree
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/wal"
+	"strings"
+	"testing"
+)
+
+// A write that fills the memtable switches it before the write returns, so
+// the next write starts a fresh memtable and WAL segment.
+func TestCommitSwitchesFullMemtable(t *testing.T) {
+	config := configs.GetStorageEngineConfig()
+
+	directory := t.TempDir() + "/"
+	previousData, previousSSTables, previousMaxSize := config.DataDirectory, config.SSTableConfig.Directory, config.MemTableConfig.MaxSize
+	config.DataDirectory = directory
+	config.SSTableConfig.Directory = directory + "sstable/"
+	config.MemTableConfig.MaxSize = 256
+
+	t.Cleanup(func() {
+		config.DataDirectory, config.SSTableConfig.Directory = previousData, previousSSTables
+		config.MemTableConfig.MaxSize = previousMaxSize
+	})
+
+	writeAheadLog, err := wal.NewWriteAheadLog(t.TempDir(), 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { writeAheadLog.Close() })
+
+	lsm := NewLSMTree(memtable.NewMemTable(), writeAheadLog)
+
+	if err := lsm.Put("small", "value"); err != nil {
+		t.Fatal(err)
+	}
+
+	if immutables := len(lsm.MemTable.Immutables); immutables != 0 {
+		t.Fatalf("got %d immutable memtables before the memtable is full, want 0", immutables)
+	}
+
+	if err := lsm.Put("large", strings.Repeat("v", config.MemTableConfig.MaxSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if immutables := len(lsm.MemTable.Immutables); immutables != 1 {
+		t.Fatalf("got %d immutable memtables once the memtable is full, want 1", immutables)
+	}
+
+	if segment := writeAheadLog.CurrentSegment(); segment != 2 {
+		t.Errorf("writing to WAL segment %d, want 2", segment)
+	}
+
+	if size := lsm.MemTable.Size(); size >= config.MemTableConfig.MaxSize {
+		t.Errorf("new memtable holds %d bytes, want it empty", size)
+	}
+}
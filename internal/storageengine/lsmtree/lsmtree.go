@@ -1,71 +1,325 @@
 package lsmtree
 
 import (
+	"fmt"
+	"log"
 	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/channels"
 	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/manifest"
 	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/mergeoperator"
 	"pkvstore/internal/storageengine/sstable"
+	"pkvstore/internal/storageengine/wal"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type LSMTree struct {
-	MemTable *memtable.MemTable
-	SSTables [][]*sstable.SSTable
+	MemTable      *memtable.MemTable
+	SSTables      [][]*sstable.SSTable
+	sstablesMutex sync.RWMutex
+	manifest      *manifest.Manifest
+	fileNumber    atomic.Uint64
+	lastSequence  atomic.Uint64 // newest sequence number applied to the memtable
+	wal           *wal.WriteAheadLog
+	writeMutex    sync.Mutex
+
+	// levels whose tables hold disjoint key ranges and are sorted by them;
+	// the others, like the first level, are searched newest first
+	disjointLevels []bool
+
+	// tables deleted by FIFO compaction and their bytes
+	droppedSSTables atomic.Uint64
+	droppedBytes    atomic.Uint64
+
+	mergeOperator      mergeoperator.MergeOperator
+	mergeOperatorMutex sync.Mutex
+
+	// last WAL segment holding writes of each immutable memtable, oldest first
+	immutableWalSegments []uint64
+	sharedChannel        *channels.SharedChannel
+	writeRequests        chan *writeRequest
+	writeStall           *writeStall
+	snapshots            snapshotList
 }
 
-func NewLSMTree() *LSMTree {
+func NewLSMTree(memTable *memtable.MemTable, writeAheadLog *wal.WriteAheadLog) *LSMTree {
 	config := configs.GetStorageEngineConfig()
 
 	sstables := make([][]*sstable.SSTable, config.LSMTreeConfig.NumberOfSSTableLevels)
 
 	lsmTree := &LSMTree{
-		MemTable: memtable.NewMemTable(),
-		SSTables: sstables,
+		MemTable:             memTable,
+		SSTables:             sstables,
+		disjointLevels:       make([]bool, len(sstables)),
+		wal:                  writeAheadLog,
+		immutableWalSegments: make([]uint64, 0),
+		sharedChannel:        channels.GetSharedChannel(),
+		writeRequests:        make(chan *writeRequest, config.WALConfig.MaxGroupCommit),
+		writeStall:           newWriteStall(),
 	}
 
+	lsmTree.loadSSTables()
+
+	go lsmTree.listenSwitchMemtableEvent()
+	go lsmTree.listenWriteRequests()
+
 	return lsmTree
 }
 
-func (lsm *LSMTree) Get(key string) *models.Result {
+// Get reads key as of snapshot, or the newest visible write when snapshot is
+// nil. It fails when an SSTable cannot be read or the merge operands of key
+// cannot be combined.
+func (lsm *LSMTree) Get(key string, snapshot *Snapshot) (*models.Result, error) {
 
 	// complexity
 	// level = 6
-	// total 63 sstables
+	// first level = every table, newest first
+	// other levels = one table, binary search on key ranges
 	// last level = 10^9 keys
 	// block cap = 2048
 	// blocks = 10^5
 	// so overall compelxity = binary search - log2(10^5)
 
-	result := lsm.MemTable.Get(key)
+	sequence := lsm.readSequence(snapshot)
+
+	result := lsm.MemTable.Get(key, sequence)
+
+	if result.Status == models.MergeOperand {
+		return lsm.getMerged(key, sequence, result.Sequence)
+	}
 
 	if result.Status == models.Found || result.Status == models.Deleted {
-		return result
+		return result, nil
 	}
 
 	config := configs.GetStorageEngineConfig()
 
+	levels, disjoint := lsm.acquireSSTables()
+	defer ReleaseSSTables(levels)
+
+	// the newest range tombstone covering key in the tables searched so far;
+	// the versions older than it are deleted
+	covering := uint64(0)
+
 	for level := config.LSMTreeConfig.FirstLevel; level >= config.LSMTreeConfig.LastLevel; level-- {
-		for sstableId := len(lsm.SSTables[level]) - 1; sstableId >= 0; sstableId-- {
-			currentSSTable := lsm.SSTables[level][sstableId]
+		sstablesInLevel := levels[level]
+
+		// a single table of a disjoint level can hold key or a range
+		// tombstone covering it
+		if disjoint[level] {
+			i := sstable.FindByKey(sstablesInLevel, key)
+			sstablesInLevel = sstablesInLevel[max(i, 0) : i+1]
+		}
+
+		for sstableId := len(sstablesInLevel) - 1; sstableId >= 0; sstableId-- {
+			currentSSTable := sstablesInLevel[sstableId]
+
+			covering = max(covering, iterator.CoveringSequence(currentSSTable.RangeTombstones, key, sequence))
 
 			if currentSSTable.DoesNotExist(key) {
 				continue
 			}
 
-			result = currentSSTable.ReadFromSSTable(key)
+			result, err := currentSSTable.ReadFromSSTable(key, sequence)
 
-			if result.Status == models.Found || result.Status == models.Deleted {
-				return result
+			if err != nil {
+				return nil, err
+			}
+
+			if result.Status == models.NotFound {
+				continue
 			}
+
+			if result.Sequence < covering {
+				return models.NewDeletedResult(covering), nil
+			}
+
+			if result.Status == models.MergeOperand {
+				return lsm.getMerged(key, sequence, result.Sequence)
+			}
+
+			return result, nil
 		}
 	}
 
-	return models.NewNotFoundResult()
+	if covering > 0 {
+		return models.NewDeletedResult(covering), nil
+	}
+
+	return models.NewNotFoundResult(), nil
+}
+
+func (lsm *LSMTree) Put(key, value string) error {
+	return lsm.write([]*wal.LogEntry{{Operation: wal.InsertOperation, Key: key, Value: value}}, len(key)+len(value))
+}
+
+// PutWithTTL writes value for key, to read as deleted once ttl has passed.
+func (lsm *LSMTree) PutWithTTL(key, value string, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl).UnixNano()
+
+	return lsm.write([]*wal.LogEntry{{Operation: wal.InsertOperation, Key: key, Value: value, ExpiresAt: expiresAt}}, len(key)+len(value))
+}
+
+// SetMergeOperator makes Merge, reads and the compactions that start from now
+// on combine merge operands with operator. With a nil operator, Merge fails,
+// and so do reads of keys holding operands.
+func (lsm *LSMTree) SetMergeOperator(operator mergeoperator.MergeOperator) {
+	lsm.mergeOperatorMutex.Lock()
+	defer lsm.mergeOperatorMutex.Unlock()
+
+	lsm.mergeOperator = operator
 }
 
-func (lsm *LSMTree) Put(key, value string) {
-	lsm.MemTable.Put(key, value)
+// MergeOperator returns the operator set by SetMergeOperator, or nil.
+func (lsm *LSMTree) MergeOperator() mergeoperator.MergeOperator {
+	lsm.mergeOperatorMutex.Lock()
+	defer lsm.mergeOperatorMutex.Unlock()
+
+	return lsm.mergeOperator
 }
 
-func (lsm *LSMTree) Delete(key string) {
-	lsm.MemTable.Delete(key)
+// Merge records operand for key, to be combined with its value by the merge
+// operator when it is read or compacted.
+func (lsm *LSMTree) Merge(key, operand string) error {
+	operator := lsm.MergeOperator()
+
+	if operator == nil {
+		return mergeoperator.ErrNoMergeOperator
+	}
+
+	// reject operands the operator cannot combine before they reach the log
+	if _, err := operator.FullMerge(key, "", false, []string{operand}); err != nil {
+		return err
+	}
+
+	return lsm.write([]*wal.LogEntry{{Operation: wal.MergeOperation, Key: key, Value: operand}}, len(key)+len(operand))
+}
+
+func (lsm *LSMTree) Delete(key string) error {
+	return lsm.write([]*wal.LogEntry{{Operation: wal.DeleteOperation, Key: key}}, len(key))
+}
+
+// DeleteRange deletes every key in [start, end) with a single range
+// tombstone. An empty end deletes every key from start on.
+func (lsm *LSMTree) DeleteRange(start, end string) error {
+	return lsm.write([]*wal.LogEntry{{Operation: wal.DeleteRangeOperation, Key: start, Value: end}}, len(start)+len(end))
+}
+
+func (lsm *LSMTree) listenSwitchMemtableEvent() {
+
+	for event := range lsm.sharedChannel.SwitchMemtableEvent {
+
+		if event < 0 {
+			continue
+		}
+
+		if _, err := lsm.switchMemtable(); err != nil {
+			log.Println("Rotating WAL segment:", err)
+		}
+
+		lsm.sharedChannel.FlushMemtableEvent <- 1
+	}
+}
+
+// switchMemtable freezes the active memtable once it is full and starts a new
+// WAL segment for the writes that follow. Holding the write mutex keeps every
+// write in the segment range of the memtable it lands in.
+func (lsm *LSMTree) switchMemtable() (bool, error) {
+	lsm.writeMutex.Lock()
+	defer lsm.writeMutex.Unlock()
+
+	return lsm.switchFullMemtable()
+}
+
+// switchFullMemtable is switchMemtable for callers holding the write mutex.
+// It reports whether the memtable was switched.
+func (lsm *LSMTree) switchFullMemtable() (bool, error) {
+	if !lsm.MemTable.SwitchMemtable() {
+		return false, nil
+	}
+
+	lastSegment := lsm.wal.CurrentSegment()
+	err := lsm.wal.Rotate()
+
+	if err != nil {
+		// new writes keep going to the current segment, so it must outlive this flush
+		lastSegment--
+	}
+
+	lsm.immutableWalSegments = append(lsm.immutableWalSegments, lastSegment)
+
+	return true, err
+}
+
+// CompleteMemtableFlush drops the oldest immutable memtable once it has been
+// written to an SSTable, along with the WAL segments that covered it.
+func (lsm *LSMTree) CompleteMemtableFlush() error {
+	lsm.writeMutex.Lock()
+	lsm.MemTable.RemoveOldestImmutable()
+	flushedSegment := lsm.immutableWalSegments[0]
+	lsm.immutableWalSegments = lsm.immutableWalSegments[1:]
+	lsm.writeMutex.Unlock()
+
+	lsm.notifyWriteStall()
+
+	config := configs.GetStorageEngineConfig()
+
+	return wal.RemoveSegments(config.WALConfig.Directory, flushedSegment, config.WALConfig.ArchiveDirectory)
+}
+
+// LastSequence returns the sequence number of the newest write visible to
+// reads.
+func (lsm *LSMTree) LastSequence() uint64 {
+	return lsm.lastSequence.Load()
+}
+
+// NewIterator returns an iterator over the keys in [start, end) as of
+// snapshot, or the newest visible write when snapshot is nil. The memtables
+// are captured before the SSTables, so a flush in between finds the flushed
+// versions in both, and the merging iterator reads them once.
+func (lsm *LSMTree) NewIterator(start string, end string, snapshot *Snapshot) *iterator.Iterator {
+	return lsm.newIterator(start, end, lsm.readSequence(snapshot))
+}
+
+func (lsm *LSMTree) newIterator(start string, end string, sequence uint64) *iterator.Iterator {
+	children, rangeTombstones := lsm.MemTable.NewIterators()
+
+	levels := lsm.AcquireSSTables()
+	defer ReleaseSSTables(levels)
+
+	config := configs.GetStorageEngineConfig()
+
+	sources := make([]iterator.InternalIterator, 0)
+
+	for level := config.LSMTreeConfig.LastLevel; level <= config.LSMTreeConfig.FirstLevel; level++ {
+		for _, table := range levels[level] {
+			sources = append(sources, table.NewIterator())
+			rangeTombstones = append(rangeTombstones, table.RangeTombstones...)
+		}
+	}
+
+	return iterator.NewIterator(append(sources, children...), rangeTombstones, start, end, sequence, lsm.MergeOperator())
+}
+
+// getMerged reads key, whose newest version as of sequence is the merge
+// operand written at newest, by walking all of its versions and combining the
+// operands with the value they apply to.
+func (lsm *LSMTree) getMerged(key string, sequence uint64, newest uint64) (*models.Result, error) {
+	it := lsm.newIterator(key, key+"\x00", sequence)
+
+	result := models.NewNotFoundResult()
+
+	if it.Valid() {
+		result = models.NewFoundResult(it.Value(), newest)
+	}
+
+	if err := it.Close(); err != nil {
+		return nil, fmt.Errorf("merging operands of %s: %w", key, err)
+	}
+
+	return result, nil
 }
@@ -0,0 +1,82 @@
+package lsmtree
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Snapshot is a consistent view of the LSM tree as of one sequence number.
+// Reads through it ignore later writes, and compactions keep the versions it
+// can see until it is released.
+type Snapshot struct {
+	sequence uint64
+	released atomic.Bool
+}
+
+// Sequence returns the sequence number of the newest write the snapshot sees.
+func (snapshot *Snapshot) Sequence() uint64 {
+	return snapshot.sequence
+}
+
+// snapshotList holds the live snapshots, oldest first.
+type snapshotList struct {
+	mutex     sync.Mutex
+	snapshots []*Snapshot
+}
+
+// GetSnapshot returns a snapshot of the newest visible write. It must be
+// released with ReleaseSnapshot.
+func (lsm *LSMTree) GetSnapshot() *Snapshot {
+	lsm.snapshots.mutex.Lock()
+	defer lsm.snapshots.mutex.Unlock()
+
+	// sequence numbers only grow, so appending keeps the list sorted
+	snapshot := &Snapshot{sequence: lsm.LastSequence()}
+	lsm.snapshots.snapshots = append(lsm.snapshots.snapshots, snapshot)
+
+	return snapshot
+}
+
+// ReleaseSnapshot lets compactions drop the versions only the snapshot could
+// see. Releasing a snapshot twice has no effect.
+func (lsm *LSMTree) ReleaseSnapshot(snapshot *Snapshot) {
+	if snapshot.released.Swap(true) {
+		return
+	}
+
+	lsm.snapshots.mutex.Lock()
+	defer lsm.snapshots.mutex.Unlock()
+
+	for i, live := range lsm.snapshots.snapshots {
+		if live == snapshot {
+			lsm.snapshots.snapshots = append(lsm.snapshots.snapshots[:i:i], lsm.snapshots.snapshots[i+1:]...)
+			return
+		}
+	}
+}
+
+// SnapshotSequences returns the sequence numbers of the live snapshots in
+// ascending order, for flushes and compactions to decide which versions to
+// keep.
+func (lsm *LSMTree) SnapshotSequences() []uint64 {
+	lsm.snapshots.mutex.Lock()
+	defer lsm.snapshots.mutex.Unlock()
+
+	sequences := make([]uint64, len(lsm.snapshots.snapshots))
+
+	for i, snapshot := range lsm.snapshots.snapshots {
+		sequences[i] = snapshot.sequence
+	}
+
+	return sequences
+}
+
+// readSequence returns the sequence number a read through snapshot sees; a
+// nil snapshot reads the newest visible write.
+func (lsm *LSMTree) readSequence(snapshot *Snapshot) uint64 {
+	if snapshot == nil {
+		return lsm.LastSequence()
+	}
+
+	return snapshot.sequence
+}
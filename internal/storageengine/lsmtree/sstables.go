@@ -0,0 +1,220 @@
+package lsmtree
+
+import (
+	"log"
+	"os"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/manifest"
+	"pkvstore/internal/storageengine/sstable"
+)
+
+// loadSSTables opens the SSTables recorded in the MANIFEST, removes SSTable
+// files no MANIFEST entry refers to and starts a fresh MANIFEST.
+func (lsm *LSMTree) loadSSTables() {
+	config := configs.GetStorageEngineConfig()
+
+	if err := os.MkdirAll(config.SSTableConfig.Directory, 0755); err != nil {
+		log.Fatal("Creating SSTable directory:", err)
+	}
+
+	version, err := manifest.Recover(config.DataDirectory, config.LSMTreeConfig.NumberOfSSTableLevels)
+
+	if err != nil {
+		log.Fatal("Recovering MANIFEST:", err)
+	}
+
+	for level, fileNumbers := range version.Levels {
+		for _, fileNumber := range fileNumbers {
+			table, err := sstable.LoadFromFile(config.SSTableConfig.Directory, fileNumber)
+
+			if err != nil {
+				log.Fatal("Loading SSTable ", fileNumber, ": ", err)
+			}
+
+			lsm.SSTables[level] = append(lsm.SSTables[level], table)
+		}
+
+		lsm.sortLevel(level)
+	}
+
+	if err := sstable.RemoveOrphanFiles(config.SSTableConfig.Directory, version.LiveFiles()); err != nil {
+		log.Fatal("Removing orphaned SSTables:", err)
+	}
+
+	lsm.manifest, err = manifest.Create(config.DataDirectory, version)
+
+	if err != nil {
+		log.Fatal("Creating MANIFEST:", err)
+	}
+
+	lsm.fileNumber.Store(version.NextFileNumber)
+
+	// the WAL segments holding the newest flushed writes may be gone, so new
+	// writes must be numbered after everything in the SSTables too
+	lsm.wal.AdvanceSequence(version.LastSequence)
+	lsm.lastSequence.Store(lsm.wal.LastSequence())
+}
+
+// NewFileNumber returns a file number no other SSTable has used.
+func (lsm *LSMTree) NewFileNumber() uint64 {
+	return lsm.fileNumber.Add(1) - 1
+}
+
+// sortLevel sorts the tables of a level below the first by key when their
+// key ranges are disjoint. Otherwise the level keeps its order, oldest first.
+func (lsm *LSMTree) sortLevel(level int) {
+	if level == configs.GetStorageEngineConfig().LSMTreeConfig.FirstLevel {
+		return
+	}
+
+	sorted := append([]*sstable.SSTable(nil), lsm.SSTables[level]...)
+	lsm.disjointLevels[level] = sstable.SortByKeyRange(sorted)
+
+	if lsm.disjointLevels[level] {
+		lsm.SSTables[level] = sorted
+	}
+}
+
+// GetSSTables returns a copy of the tables in a level: sorted by key when
+// their key ranges are disjoint, oldest first otherwise.
+func (lsm *LSMTree) GetSSTables(level int) []*sstable.SSTable {
+	lsm.sstablesMutex.RLock()
+	defer lsm.sstablesMutex.RUnlock()
+
+	return append([]*sstable.SSTable(nil), lsm.SSTables[level]...)
+}
+
+// HasDisjointTables reports whether the tables of a level hold disjoint key
+// ranges.
+func (lsm *LSMTree) HasDisjointTables(level int) bool {
+	lsm.sstablesMutex.RLock()
+	defer lsm.sstablesMutex.RUnlock()
+
+	return lsm.disjointLevels[level]
+}
+
+// AcquireSSTables returns the tables of every level, ordered as by
+// GetSSTables, holding a reference on each so that a compaction cannot close
+// them while they are read.
+func (lsm *LSMTree) AcquireSSTables() [][]*sstable.SSTable {
+	levels, _ := lsm.acquireSSTables()
+
+	return levels
+}
+
+// acquireSSTables is AcquireSSTables that also reports which levels hold
+// disjoint key ranges.
+func (lsm *LSMTree) acquireSSTables() ([][]*sstable.SSTable, []bool) {
+	lsm.sstablesMutex.RLock()
+	defer lsm.sstablesMutex.RUnlock()
+
+	levels := make([][]*sstable.SSTable, len(lsm.SSTables))
+
+	for level, tables := range lsm.SSTables {
+		levels[level] = append([]*sstable.SSTable(nil), tables...)
+
+		for _, table := range tables {
+			table.Ref()
+		}
+	}
+
+	return levels, append([]bool(nil), lsm.disjointLevels...)
+}
+
+// ReleaseSSTables drops the references taken by AcquireSSTables.
+func ReleaseSSTables(levels [][]*sstable.SSTable) {
+	for _, tables := range levels {
+		for _, table := range tables {
+			table.Unref()
+		}
+	}
+}
+
+// AddSSTable records a table written to disk in the MANIFEST and makes it
+// visible to readers.
+func (lsm *LSMTree) AddSSTable(table *sstable.SSTable) error {
+	return lsm.ReplaceSSTables(nil, []*sstable.SSTable{table})
+}
+
+// ReplaceSSTables swaps compacted tables for their merged output, first in the
+// MANIFEST and then for readers. Tables added while the compaction ran are
+// kept. The files of the compacted tables are deleted once their last reader
+// is done.
+func (lsm *LSMTree) ReplaceSSTables(compacted []*sstable.SSTable, merged []*sstable.SSTable) error {
+	if err := lsm.replaceSSTables(compacted, merged); err != nil {
+		return err
+	}
+
+	lsm.notifyWriteStall()
+
+	return nil
+}
+
+// DropSSTables deletes tables without merging them anywhere, so their keys
+// read as they did before the tables were written.
+func (lsm *LSMTree) DropSSTables(tables []*sstable.SSTable) error {
+	size := uint64(0)
+
+	for _, table := range tables {
+		size += table.Size()
+	}
+
+	if err := lsm.ReplaceSSTables(tables, nil); err != nil {
+		return err
+	}
+
+	lsm.droppedSSTables.Add(uint64(len(tables)))
+	lsm.droppedBytes.Add(size)
+
+	return nil
+}
+
+func (lsm *LSMTree) replaceSSTables(compacted []*sstable.SSTable, merged []*sstable.SSTable) error {
+	lsm.sstablesMutex.Lock()
+	defer lsm.sstablesMutex.Unlock()
+
+	edit := &manifest.VersionEdit{NextFileNumber: lsm.fileNumber.Load(), LastSequence: lsm.LastSequence()}
+
+	for _, table := range merged {
+		edit.AddedFiles = append(edit.AddedFiles, manifest.FileMetadata{Level: table.Header.Level, FileNumber: table.FileNumber})
+	}
+
+	for _, table := range compacted {
+		edit.RemovedFiles = append(edit.RemovedFiles, manifest.FileMetadata{Level: table.Header.Level, FileNumber: table.FileNumber})
+	}
+
+	if err := lsm.manifest.LogEdit(edit); err != nil {
+		return err
+	}
+
+	isCompacted := make(map[*sstable.SSTable]bool, len(compacted))
+	for _, table := range compacted {
+		isCompacted[table] = true
+	}
+
+	for level, tables := range lsm.SSTables {
+		remaining := make([]*sstable.SSTable, 0, len(tables))
+
+		for _, table := range tables {
+			if !isCompacted[table] {
+				remaining = append(remaining, table)
+			}
+		}
+
+		lsm.SSTables[level] = remaining
+	}
+
+	for _, table := range merged {
+		lsm.SSTables[table.Header.Level] = append(lsm.SSTables[table.Header.Level], table)
+	}
+
+	for level := range lsm.SSTables {
+		lsm.sortLevel(level)
+	}
+
+	for _, table := range compacted {
+		table.MarkObsolete()
+	}
+
+	return nil
+}
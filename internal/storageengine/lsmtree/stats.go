@@ -0,0 +1,39 @@
+package lsmtree
+
+import "pkvstore/internal/storageengine/configs"
+
+// Stats is a point-in-time view of the LSM tree's shape and write stalls.
+type Stats struct {
+	ActiveMemtableSize int
+	ImmutableMemtables int
+	SSTablesPerLevel   []int
+	BytesPerLevel      []uint64
+	CompactionStyle    configs.CompactionStyle
+	DroppedSSTables    uint64 // deleted by FIFO compaction
+	DroppedBytes       uint64
+	WriteStall         WriteStallStats
+}
+
+func (lsm *LSMTree) Stats() *Stats {
+	lsm.sstablesMutex.RLock()
+	sstablesPerLevel := make([]int, len(lsm.SSTables))
+	bytesPerLevel := make([]uint64, len(lsm.SSTables))
+	for level, tables := range lsm.SSTables {
+		sstablesPerLevel[level] = len(tables)
+		for _, table := range tables {
+			bytesPerLevel[level] += table.Size()
+		}
+	}
+	lsm.sstablesMutex.RUnlock()
+
+	return &Stats{
+		ActiveMemtableSize: lsm.MemTable.Size(),
+		ImmutableMemtables: lsm.MemTable.NumberOfImmutables(),
+		SSTablesPerLevel:   sstablesPerLevel,
+		BytesPerLevel:      bytesPerLevel,
+		CompactionStyle:    configs.GetStorageEngineConfig().LSMTreeConfig.CompactionStyle,
+		DroppedSSTables:    lsm.droppedSSTables.Load(),
+		DroppedBytes:       lsm.droppedBytes.Load(),
+		WriteStall:         lsm.WriteStallStats(),
+	}
+}
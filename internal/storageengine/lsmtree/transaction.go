@@ -0,0 +1,16 @@
+package lsmtree
+
+import "errors"
+
+var ErrTransactionConflict = errors.New("transaction conflict: a key was modified after the transaction started")
+
+// WriteIfUnchanged applies the batch atomically unless one of keys has a
+// version newer than sequence, in which case it returns
+// ErrTransactionConflict and writes nothing.
+func (lsm *LSMTree) WriteIfUnchanged(batch *WriteBatch, keys []string, sequence uint64) error {
+	return lsm.submit(&writeRequest{
+		entries:          batch.copyEntries(),
+		conflictKeys:     keys,
+		conflictSequence: sequence,
+	}, batch.size())
+}
@@ -0,0 +1,75 @@
+package lsmtree
+
+import (
+	"pkvstore/internal/storageengine/wal"
+)
+
+// WriteBatch collects puts and deletes that are applied atomically, in the
+// order they were added, by LSMTree.Write.
+type WriteBatch struct {
+	entries []*wal.LogEntry
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{
+		entries: make([]*wal.LogEntry, 0),
+	}
+}
+
+func (batch *WriteBatch) Put(key string, value string) {
+	batch.entries = append(batch.entries, &wal.LogEntry{Operation: wal.InsertOperation, Key: key, Value: value})
+}
+
+func (batch *WriteBatch) Delete(key string) {
+	batch.entries = append(batch.entries, &wal.LogEntry{Operation: wal.DeleteOperation, Key: key})
+}
+
+// DeleteRange deletes every key in [start, end) with a single range
+// tombstone. An empty end deletes every key from start on.
+func (batch *WriteBatch) DeleteRange(start string, end string) {
+	batch.entries = append(batch.entries, &wal.LogEntry{Operation: wal.DeleteRangeOperation, Key: start, Value: end})
+}
+
+// Clear empties the batch so it can be reused.
+func (batch *WriteBatch) Clear() {
+	batch.entries = batch.entries[:0]
+}
+
+// Count returns the number of operations in the batch.
+func (batch *WriteBatch) Count() int {
+	return len(batch.entries)
+}
+
+// size returns the bytes of keys and values in the batch.
+func (batch *WriteBatch) size() int {
+	size := 0
+
+	for _, entry := range batch.entries {
+		size += len(entry.Key) + len(entry.Value)
+	}
+
+	return size
+}
+
+// Write applies the batch atomically: it is logged as one WAL record and
+// becomes visible to reads all at once.
+func (lsm *LSMTree) Write(batch *WriteBatch) error {
+	if batch.Count() == 0 {
+		return nil
+	}
+
+	return lsm.write(batch.copyEntries(), batch.size())
+}
+
+// copyEntries returns copies of the entries for the WAL to number, so the
+// batch can be reused once it is written.
+func (batch *WriteBatch) copyEntries() []*wal.LogEntry {
+	entries := make([]*wal.LogEntry, len(batch.entries))
+
+	for i, entry := range batch.entries {
+		copied := *entry
+		entries[i] = &copied
+	}
+
+	return entries
+}
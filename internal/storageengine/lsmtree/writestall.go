@@ -0,0 +1,149 @@
+package lsmtree
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WriteStallState tells how writes are throttled while flushes or compactions
+// fall behind.
+type WriteStallState int
+
+const (
+	NotStalled   WriteStallState = iota
+	DelayedWrite                 // writes are rate limited to WriteStallConfig.DelayedWriteRate
+	StoppedWrite                 // writes wait until the backlog shrinks
+)
+
+func (state WriteStallState) String() string {
+	switch state {
+	case DelayedWrite:
+		return "delayed"
+	case StoppedWrite:
+		return "stopped"
+	default:
+		return "normal"
+	}
+}
+
+// WriteStallStats counts the writes held back by stalls and how long they
+// waited in total.
+type WriteStallStats struct {
+	State           WriteStallState
+	DelayedWrites   uint64
+	StoppedWrites   uint64
+	DelayedDuration time.Duration
+	StoppedDuration time.Duration
+}
+
+type writeStall struct {
+	mutex sync.Mutex
+	// signalled whenever a flush or compaction may have shrunk the backlog
+	backlogShrunk *sync.Cond
+	// earliest time the next delayed write may proceed
+	nextDelayedWrite time.Time
+
+	delayedWrites   atomic.Uint64
+	stoppedWrites   atomic.Uint64
+	delayedDuration atomic.Int64
+	stoppedDuration atomic.Int64
+}
+
+func newWriteStall() *writeStall {
+	stall := &writeStall{}
+	stall.backlogShrunk = sync.NewCond(&stall.mutex)
+
+	return stall
+}
+
+// writeStallState derives the stall state from the immutable memtables
+// waiting for flush and the tables waiting for compaction in the first level.
+// FIFO compaction never merges first-level tables, so only the memtables
+// count then.
+func (lsm *LSMTree) writeStallState() WriteStallState {
+	config := configs.GetStorageEngineConfig()
+
+	immutables := lsm.MemTable.NumberOfImmutables()
+	firstLevelTables := 0
+
+	if config.LSMTreeConfig.CompactionStyle != configs.FIFOCompaction {
+		firstLevelTables = len(lsm.GetSSTables(config.LSMTreeConfig.FirstLevel))
+	}
+
+	if immutables >= config.WriteStallConfig.StopImmutableMemtables || firstLevelTables >= config.WriteStallConfig.StopFirstLevelSSTables {
+		return StoppedWrite
+	}
+
+	if immutables >= config.WriteStallConfig.SlowdownImmutableMemtables || firstLevelTables >= config.WriteStallConfig.SlowdownFirstLevelSSTables {
+		return DelayedWrite
+	}
+
+	return NotStalled
+}
+
+// waitForWriteStall blocks a write of size bytes while writes are stopped and
+// paces it while they are delayed.
+func (lsm *LSMTree) waitForWriteStall(size int) {
+	stall := lsm.writeStall
+	state := lsm.writeStallState()
+
+	if state == StoppedWrite {
+		start := time.Now()
+
+		stall.mutex.Lock()
+		for lsm.writeStallState() == StoppedWrite {
+			stall.backlogShrunk.Wait()
+		}
+		stall.mutex.Unlock()
+
+		stall.stoppedWrites.Add(1)
+		stall.stoppedDuration.Add(int64(time.Since(start)))
+
+		state = lsm.writeStallState()
+	}
+
+	if state != DelayedWrite {
+		return
+	}
+
+	config := configs.GetStorageEngineConfig()
+	cost := time.Duration(float64(size) / float64(config.WriteStallConfig.DelayedWriteRate) * float64(time.Second))
+
+	// delayed writers queue up behind each other so that together they stay
+	// under the configured rate
+	stall.mutex.Lock()
+	now := time.Now()
+	if stall.nextDelayedWrite.Before(now) {
+		stall.nextDelayedWrite = now
+	}
+	delay := stall.nextDelayedWrite.Sub(now) + cost
+	stall.nextDelayedWrite = stall.nextDelayedWrite.Add(cost)
+	stall.mutex.Unlock()
+
+	time.Sleep(delay)
+
+	stall.delayedWrites.Add(1)
+	stall.delayedDuration.Add(int64(delay))
+}
+
+// notifyWriteStall wakes stopped writers after the backlog may have shrunk.
+// Callers must not hold sstablesMutex, which writeStallState takes under the
+// stall mutex.
+func (lsm *LSMTree) notifyWriteStall() {
+	lsm.writeStall.mutex.Lock()
+	lsm.writeStall.backlogShrunk.Broadcast()
+	lsm.writeStall.mutex.Unlock()
+}
+
+// WriteStallStats returns the current stall state and the stalls so far.
+func (lsm *LSMTree) WriteStallStats() WriteStallStats {
+	return WriteStallStats{
+		State:           lsm.writeStallState(),
+		DelayedWrites:   lsm.writeStall.delayedWrites.Load(),
+		StoppedWrites:   lsm.writeStall.stoppedWrites.Load(),
+		DelayedDuration: time.Duration(lsm.writeStall.delayedDuration.Load()),
+		StoppedDuration: time.Duration(lsm.writeStall.stoppedDuration.Load()),
+	}
+}
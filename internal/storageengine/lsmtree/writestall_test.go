@@ -0,0 +1,151 @@
+package lsmtree
+
+import (
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/sstable"
+	"testing"
+	"time"
+)
+
+func TestWriteStallState(t *testing.T) {
+	setWriteStallConfig(t, configs.GetStorageEngineConfig().WriteStallConfig.DelayedWriteRate)
+
+	tests := []struct {
+		name             string
+		immutables       int
+		firstLevelTables int
+		style            configs.CompactionStyle
+		want             WriteStallState
+	}{
+		{name: "no backlog", want: NotStalled},
+		{name: "immutables below slowdown", immutables: 1, firstLevelTables: 1, want: NotStalled},
+		{name: "immutables at slowdown", immutables: 2, want: DelayedWrite},
+		{name: "immutables at stop", immutables: 3, want: StoppedWrite},
+		{name: "first level at slowdown", firstLevelTables: 4, want: DelayedWrite},
+		{name: "first level at stop", firstLevelTables: 6, want: StoppedWrite},
+		{name: "stop wins over slowdown", immutables: 2, firstLevelTables: 6, want: StoppedWrite},
+		{name: "first level under FIFO compaction", firstLevelTables: 6, style: configs.FIFOCompaction, want: NotStalled},
+		{name: "immutables under FIFO compaction", immutables: 3, style: configs.FIFOCompaction, want: StoppedWrite},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := configs.GetStorageEngineConfig()
+			previous := config.LSMTreeConfig.CompactionStyle
+			config.LSMTreeConfig.CompactionStyle = test.style
+			t.Cleanup(func() { config.LSMTreeConfig.CompactionStyle = previous })
+
+			lsm := newStallTestTree(test.immutables, test.firstLevelTables)
+
+			if got := lsm.writeStallState(); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStoppedWriteWaitsForBacklog(t *testing.T) {
+	setWriteStallConfig(t, configs.GetStorageEngineConfig().WriteStallConfig.DelayedWriteRate)
+
+	lsm := newStallTestTree(3, 0)
+	done := make(chan struct{})
+
+	go func() {
+		lsm.waitForWriteStall(1)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("write went through while writes are stopped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// one flush leaves the backlog at the slowdown trigger, which still
+	// wakes the writer
+	lsm.MemTable.RemoveOldestImmutable()
+	lsm.notifyWriteStall()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write still waits after the backlog shrank")
+	}
+
+	stats := lsm.WriteStallStats()
+
+	if stats.StoppedWrites != 1 || stats.StoppedDuration <= 0 {
+		t.Errorf("stopped writes %d for %v, want 1 for a positive duration", stats.StoppedWrites, stats.StoppedDuration)
+	}
+
+	if stats.DelayedWrites != 1 {
+		t.Errorf("delayed writes %d, want 1", stats.DelayedWrites)
+	}
+}
+
+func TestDelayedWritesKeepToTheRate(t *testing.T) {
+	const (
+		rate   = 10000 // bytes per second
+		size   = 100
+		writes = 5
+	)
+
+	setWriteStallConfig(t, rate)
+
+	lsm := newStallTestTree(2, 0)
+	start := time.Now()
+
+	for i := 0; i < writes; i++ {
+		lsm.waitForWriteStall(size)
+	}
+
+	want := time.Duration(writes*size) * time.Second / rate
+
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("%d writes took %v, want at least %v", writes, elapsed, want)
+	}
+
+	stats := lsm.WriteStallStats()
+
+	if stats.State != DelayedWrite || stats.DelayedWrites != writes || stats.StoppedWrites != 0 {
+		t.Errorf("got %+v, want %d delayed writes in the delayed state", stats, writes)
+	}
+}
+
+// newStallTestTree returns a tree with the given number of immutable
+// memtables and first-level tables waiting.
+func newStallTestTree(immutables int, firstLevelTables int) *LSMTree {
+	config := configs.GetStorageEngineConfig()
+
+	lsm := &LSMTree{
+		MemTable:   memtable.NewMemTable(),
+		SSTables:   make([][]*sstable.SSTable, config.LSMTreeConfig.NumberOfSSTableLevels),
+		writeStall: newWriteStall(),
+	}
+
+	for i := 0; i < immutables; i++ {
+		lsm.MemTable.Immutables = append(lsm.MemTable.Immutables, memtable.NewSkipList())
+	}
+
+	for i := 0; i < firstLevelTables; i++ {
+		lsm.SSTables[config.LSMTreeConfig.FirstLevel] = append(lsm.SSTables[config.LSMTreeConfig.FirstLevel], &sstable.SSTable{})
+	}
+
+	return lsm
+}
+
+// setWriteStallConfig slows writes down at 2 immutable memtables or 4
+// first-level tables and stops them at 3 or 6.
+func setWriteStallConfig(t *testing.T, delayedWriteRate int) {
+	config := configs.GetStorageEngineConfig()
+	previous := config.WriteStallConfig
+
+	config.WriteStallConfig.SlowdownImmutableMemtables = 2
+	config.WriteStallConfig.StopImmutableMemtables = 3
+	config.WriteStallConfig.SlowdownFirstLevelSSTables = 4
+	config.WriteStallConfig.StopFirstLevelSSTables = 6
+	config.WriteStallConfig.DelayedWriteRate = delayedWriteRate
+
+	t.Cleanup(func() { config.WriteStallConfig = previous })
+}
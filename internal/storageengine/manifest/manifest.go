@@ -0,0 +1,412 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The MANIFEST is a log of VersionEdits describing how the set of live
+// SSTables changed over time. Replaying it from the start yields the current
+// shape of the LSM tree. CURRENT names the MANIFEST in use; it is replaced
+// atomically with a rename whenever a new MANIFEST is started.
+//
+// Every edit is framed like a WAL record:
+//
+//	checksum (uint32) | length (uint32) | payload (length bytes)
+//
+// where the payload is:
+//
+//	next file number (uint64) | added count (uint32) | added files | removed count (uint32) | removed files | last sequence (uint64)
+//
+// and each file is a level (uint8) followed by a file number (uint64).
+const (
+	CURRENT_FILE_NAME = "CURRENT"
+	MANIFEST_PREFIX   = "MANIFEST-"
+
+	recordHeaderSize = 4 + 4
+	fileMetadataSize = 1 + 8
+)
+
+var ErrCorruptedManifest = errors.New("manifest: corrupted record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FileMetadata identifies an SSTable file and the level it lives in.
+type FileMetadata struct {
+	Level      uint8
+	FileNumber uint64
+}
+
+// VersionEdit records the files added to and removed from the LSM tree by one
+// flush or compaction. LastSequence is at least the sequence number of every
+// entry in the added files.
+type VersionEdit struct {
+	NextFileNumber uint64
+	LastSequence   uint64
+	AddedFiles     []FileMetadata
+	RemovedFiles   []FileMetadata
+}
+
+// Version is the set of live SSTable file numbers per level, oldest first,
+// along with the newest sequence number any of them may hold.
+type Version struct {
+	Levels         [][]uint64
+	NextFileNumber uint64
+	LastSequence   uint64
+}
+
+func newVersion(numberOfLevels int) *Version {
+	return &Version{
+		Levels:         make([][]uint64, numberOfLevels),
+		NextFileNumber: 1,
+	}
+}
+
+// Apply replays an edit on top of the version.
+func (version *Version) Apply(edit *VersionEdit) {
+	for _, removed := range edit.RemovedFiles {
+		files := version.Levels[removed.Level]
+
+		for i, fileNumber := range files {
+			if fileNumber == removed.FileNumber {
+				version.Levels[removed.Level] = append(files[:i:i], files[i+1:]...)
+				break
+			}
+		}
+	}
+
+	for _, added := range edit.AddedFiles {
+		version.Levels[added.Level] = append(version.Levels[added.Level], added.FileNumber)
+	}
+
+	if edit.NextFileNumber > version.NextFileNumber {
+		version.NextFileNumber = edit.NextFileNumber
+	}
+
+	if edit.LastSequence > version.LastSequence {
+		version.LastSequence = edit.LastSequence
+	}
+}
+
+// LiveFiles returns the file numbers referenced by the version.
+func (version *Version) LiveFiles() map[uint64]bool {
+	live := make(map[uint64]bool)
+
+	for _, files := range version.Levels {
+		for _, fileNumber := range files {
+			live[fileNumber] = true
+		}
+	}
+
+	return live
+}
+
+// snapshot returns a single edit that rebuilds the whole version.
+func (version *Version) snapshot() *VersionEdit {
+	edit := &VersionEdit{NextFileNumber: version.NextFileNumber, LastSequence: version.LastSequence}
+
+	for level, files := range version.Levels {
+		for _, fileNumber := range files {
+			edit.AddedFiles = append(edit.AddedFiles, FileMetadata{Level: uint8(level), FileNumber: fileNumber})
+		}
+	}
+
+	return edit
+}
+
+// Manifest appends VersionEdits to the active MANIFEST file.
+type Manifest struct {
+	file *os.File
+}
+
+// Recover rebuilds the version recorded by the MANIFEST that CURRENT points
+// to. A directory without CURRENT yields an empty version.
+func Recover(directory string, numberOfLevels int) (*Version, error) {
+	version := newVersion(numberOfLevels)
+
+	current, err := os.ReadFile(filepath.Join(directory, CURRENT_FILE_NAME))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return version, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(directory, strings.TrimSpace(string(current))))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var edit *VersionEdit
+
+		edit, data, err = readEdit(data)
+
+		// a torn final edit was never acknowledged, so the version before it is current
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return version, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range append(edit.AddedFiles, edit.RemovedFiles...) {
+			if int(file.Level) >= numberOfLevels {
+				return nil, ErrCorruptedManifest
+			}
+		}
+
+		version.Apply(edit)
+	}
+}
+
+// Create starts a new MANIFEST holding a snapshot of version, points CURRENT
+// at it and removes the older MANIFEST files. The new MANIFEST takes the next
+// file number from version.
+func Create(directory string, version *Version) (*Manifest, error) {
+	manifestNumber := version.NextFileNumber
+	version.NextFileNumber++
+
+	manifestName := fmt.Sprintf("%s%06d", MANIFEST_PREFIX, manifestNumber)
+
+	file, err := os.OpenFile(filepath.Join(directory, manifestName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		file: file,
+	}
+
+	if err := manifest.LogEdit(version.snapshot()); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// the new MANIFEST must survive a crash before CURRENT points at it
+	if err := syncDirectory(directory); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := setCurrent(directory, manifestName); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := removeOldManifests(directory, manifestName); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// LogEdit durably appends an edit to the MANIFEST.
+func (manifest *Manifest) LogEdit(edit *VersionEdit) error {
+	if _, err := manifest.file.Write(encodeEdit(edit)); err != nil {
+		return err
+	}
+
+	return manifest.file.Sync()
+}
+
+// Close closes the MANIFEST file.
+func (manifest *Manifest) Close() error {
+	return manifest.file.Close()
+}
+
+func setCurrent(directory string, manifestName string) error {
+	tempFilename := filepath.Join(directory, CURRENT_FILE_NAME+".tmp")
+
+	file, err := os.Create(tempFilename)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.WriteString(manifestName + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFilename, filepath.Join(directory, CURRENT_FILE_NAME)); err != nil {
+		return err
+	}
+
+	// the older MANIFEST files are removed next, so the rename must be
+	// durable first
+	return syncDirectory(directory)
+}
+
+// syncDirectory makes the files created, renamed and removed in directory
+// durable.
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+
+	if err != nil {
+		return err
+	}
+
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+
+	return dir.Close()
+}
+
+func removeOldManifests(directory string, manifestName string) error {
+	dirEntries, err := os.ReadDir(directory)
+
+	if err != nil {
+		return err
+	}
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+
+		if name == manifestName || !strings.HasPrefix(name, MANIFEST_PREFIX) {
+			continue
+		}
+
+		if _, err := strconv.ParseUint(strings.TrimPrefix(name, MANIFEST_PREFIX), 10, 64); err != nil {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(directory, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func encodeEdit(edit *VersionEdit) []byte {
+	length := 8 + 4 + len(edit.AddedFiles)*fileMetadataSize + 4 + len(edit.RemovedFiles)*fileMetadataSize + 8
+
+	record := make([]byte, recordHeaderSize, recordHeaderSize+length)
+	binary.LittleEndian.PutUint32(record[4:8], uint32(length))
+
+	record = binary.LittleEndian.AppendUint64(record, edit.NextFileNumber)
+	record = appendFiles(record, edit.AddedFiles)
+	record = appendFiles(record, edit.RemovedFiles)
+	record = binary.LittleEndian.AppendUint64(record, edit.LastSequence)
+
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crcTable))
+
+	return record
+}
+
+func appendFiles(record []byte, files []FileMetadata) []byte {
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(files)))
+
+	for _, file := range files {
+		record = append(record, file.Level)
+		record = binary.LittleEndian.AppendUint64(record, file.FileNumber)
+	}
+
+	return record
+}
+
+// readEdit decodes the edit at the start of data and returns the remaining
+// bytes. A record cut short by the end of data is reported as io.ErrUnexpectedEOF.
+func readEdit(data []byte) (*VersionEdit, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, io.EOF
+	}
+
+	if len(data) < recordHeaderSize {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	checksum := binary.LittleEndian.Uint32(data[0:4])
+	length := int64(binary.LittleEndian.Uint32(data[4:8]))
+
+	if recordHeaderSize+length > int64(len(data)) {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+
+	record := data[:recordHeaderSize+length]
+
+	if crc32.Checksum(record[4:], crcTable) != checksum {
+		return nil, nil, ErrCorruptedManifest
+	}
+
+	edit, err := decodeEdit(record[recordHeaderSize:])
+
+	return edit, data[len(record):], err
+}
+
+func decodeEdit(payload []byte) (*VersionEdit, error) {
+	if len(payload) < 8 {
+		return nil, ErrCorruptedManifest
+	}
+
+	edit := &VersionEdit{NextFileNumber: binary.LittleEndian.Uint64(payload[0:8])}
+	payload = payload[8:]
+
+	var err error
+
+	if edit.AddedFiles, payload, err = decodeFiles(payload); err != nil {
+		return nil, err
+	}
+
+	if edit.RemovedFiles, payload, err = decodeFiles(payload); err != nil {
+		return nil, err
+	}
+
+	if len(payload) != 8 {
+		return nil, ErrCorruptedManifest
+	}
+
+	edit.LastSequence = binary.LittleEndian.Uint64(payload)
+
+	return edit, nil
+}
+
+func decodeFiles(payload []byte) ([]FileMetadata, []byte, error) {
+	if len(payload) < 4 {
+		return nil, nil, ErrCorruptedManifest
+	}
+
+	count := int(binary.LittleEndian.Uint32(payload[0:4]))
+	payload = payload[4:]
+
+	if count*fileMetadataSize > len(payload) {
+		return nil, nil, ErrCorruptedManifest
+	}
+
+	files := make([]FileMetadata, count)
+
+	for i := range files {
+		files[i] = FileMetadata{
+			Level:      payload[0],
+			FileNumber: binary.LittleEndian.Uint64(payload[1:9]),
+		}
+		payload = payload[fileMetadataSize:]
+	}
+
+	return files, payload, nil
+}
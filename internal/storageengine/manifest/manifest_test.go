@@ -0,0 +1,220 @@
+package manifest
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const numberOfLevels = 3
+
+func TestRecoverWithoutCurrent(t *testing.T) {
+	version, err := Recover(t.TempDir(), numberOfLevels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := newVersion(numberOfLevels); !reflect.DeepEqual(version, want) {
+		t.Errorf("got %+v, want %+v", version, want)
+	}
+}
+
+func TestCreateAndRecover(t *testing.T) {
+	tests := []struct {
+		name  string
+		edits []*VersionEdit
+		want  *Version
+	}{
+		{
+			name: "no edits",
+			want: &Version{Levels: [][]uint64{nil, nil, nil}, NextFileNumber: 2},
+		},
+		{
+			name: "flushes",
+			edits: []*VersionEdit{
+				{NextFileNumber: 3, LastSequence: 10, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 2}}},
+				{NextFileNumber: 4, LastSequence: 20, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 3}}},
+			},
+			want: &Version{Levels: [][]uint64{nil, nil, {2, 3}}, NextFileNumber: 4, LastSequence: 20},
+		},
+		{
+			name: "compaction",
+			edits: []*VersionEdit{
+				{NextFileNumber: 4, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 2}, {Level: 2, FileNumber: 3}}},
+				{
+					NextFileNumber: 6,
+					AddedFiles:     []FileMetadata{{Level: 1, FileNumber: 4}, {Level: 1, FileNumber: 5}},
+					RemovedFiles:   []FileMetadata{{Level: 2, FileNumber: 2}, {Level: 2, FileNumber: 3}},
+				},
+			},
+			want: &Version{Levels: [][]uint64{nil, {4, 5}, {}}, NextFileNumber: 6},
+		},
+		{
+			name: "compaction does not move the last sequence back",
+			edits: []*VersionEdit{
+				{NextFileNumber: 3, LastSequence: 10, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 2}}},
+				{NextFileNumber: 4, AddedFiles: []FileMetadata{{Level: 1, FileNumber: 3}}, RemovedFiles: []FileMetadata{{Level: 2, FileNumber: 2}}},
+			},
+			want: &Version{Levels: [][]uint64{nil, {3}, {}}, NextFileNumber: 4, LastSequence: 10},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			logEdits(t, directory, test.edits)
+
+			version, err := Recover(directory, numberOfLevels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(version, test.want) {
+				t.Errorf("got %+v, want %+v", version, test.want)
+			}
+
+			// a restart starts a new MANIFEST holding the same version
+			manifest, err := Create(directory, version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			manifest.Close()
+
+			recovered, err := Recover(directory, numberOfLevels)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(recovered.LiveFiles(), test.want.LiveFiles()) || recovered.NextFileNumber != test.want.NextFileNumber+1 ||
+				recovered.LastSequence != test.want.LastSequence {
+				t.Errorf("after restart got %+v, want the files of %+v", recovered, test.want)
+			}
+
+			if names := manifestNames(t, directory); len(names) != 1 || names[0] != currentManifest(t, directory) {
+				t.Errorf("MANIFEST files %v, want only the current one", names)
+			}
+		})
+	}
+}
+
+func TestRecoverDamagedManifest(t *testing.T) {
+	edits := []*VersionEdit{
+		{NextFileNumber: 3, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 2}}},
+		{NextFileNumber: 4, AddedFiles: []FileMetadata{{Level: 2, FileNumber: 3}}},
+	}
+
+	// the last edit, which adds file 3
+	lastEdit := func(data []byte) int { return len(data) - len(encodeEdit(edits[1])) }
+
+	tests := []struct {
+		name      string
+		damage    func(data []byte) []byte
+		wantFiles map[uint64]bool
+		wantErr   error
+	}{
+		{
+			name:      "torn last edit",
+			damage:    func(data []byte) []byte { return data[:len(data)-1] },
+			wantFiles: map[uint64]bool{2: true},
+		},
+		{
+			name:      "torn record header",
+			damage:    func(data []byte) []byte { return data[:lastEdit(data)+3] },
+			wantFiles: map[uint64]bool{2: true},
+		},
+		{
+			name:    "bad checksum",
+			damage:  func(data []byte) []byte { data[lastEdit(data)+recordHeaderSize] ^= 0xff; return data },
+			wantErr: ErrCorruptedManifest,
+		},
+		{
+			name: "level out of range",
+			damage: func(data []byte) []byte {
+				edit := encodeEdit(&VersionEdit{AddedFiles: []FileMetadata{{Level: numberOfLevels, FileNumber: 9}}})
+				return append(data, edit...)
+			},
+			wantErr: ErrCorruptedManifest,
+		},
+		{
+			name: "edit without the last sequence",
+			damage: func(data []byte) []byte {
+				record := encodeEdit(&VersionEdit{NextFileNumber: 5})
+				record = record[:len(record)-8]
+				binary.LittleEndian.PutUint32(record[4:8], uint32(len(record)-recordHeaderSize))
+				binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crcTable))
+				return append(data, record...)
+			},
+			wantErr: ErrCorruptedManifest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			logEdits(t, directory, edits)
+
+			filename := filepath.Join(directory, currentManifest(t, directory))
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filename, test.damage(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			version, err := Recover(directory, numberOfLevels)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+
+			if err == nil && !reflect.DeepEqual(version.LiveFiles(), test.wantFiles) {
+				t.Errorf("got files %v, want %v", version.LiveFiles(), test.wantFiles)
+			}
+		})
+	}
+}
+
+// logEdits creates a MANIFEST in directory and logs edits to it.
+func logEdits(t *testing.T, directory string, edits []*VersionEdit) {
+	manifest, err := Create(directory, newVersion(numberOfLevels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+
+	for _, edit := range edits {
+		if err := manifest.LogEdit(edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func currentManifest(t *testing.T, directory string) string {
+	current, err := os.ReadFile(filepath.Join(directory, CURRENT_FILE_NAME))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.TrimSpace(string(current))
+}
+
+func manifestNames(t *testing.T, directory string) []string {
+	names, err := filepath.Glob(filepath.Join(directory, MANIFEST_PREFIX+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range names {
+		names[i] = filepath.Base(name)
+	}
+
+	return names
+}
@@ -1,124 +1,186 @@
 package memtable
 
 import (
-	"os"
 	"pkvstore/internal/models"
-	"pkvstore/internal/storageengine/channels"
 	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
 	"sync"
+	"time"
 )
 
 type MemTableEntry struct {
+	Sequence    uint64
 	Value       string
 	IsTombstone bool
+	IsMerge     bool  // Value is a merge operand
+	ExpiresAt   int64 // Unix time in nanoseconds after which the value reads as deleted, 0 for never
 }
 
-func NewMemTableEntry(value string) *MemTableEntry {
+func NewMemTableEntry(value string, sequence uint64) *MemTableEntry {
 	return &MemTableEntry{
+		Sequence:    sequence,
 		Value:       value,
 		IsTombstone: false,
 	}
 }
 
+// MemTable holds the writes not yet flushed to an SSTable: the active table
+// and the full tables waiting for flush, oldest first. Writes must be
+// serialized by the caller; the mutex only guards switching the tables.
 type MemTable struct {
-	Table         map[string]*MemTableEntry
-	ReadOnlyTable map[string]*MemTableEntry
-	size          uint32
-	mutex         sync.RWMutex
-	sharedChannel *channels.SharedChannel
+	Table      *SkipList
+	Immutables []*SkipList
+	mutex      sync.RWMutex
 }
 
 func NewMemTable() *MemTable {
-	m := &MemTable{
-		Table:         make(map[string]*MemTableEntry),
-		ReadOnlyTable: nil,
-		size:          0,
-		sharedChannel: channels.GetSharedChannel(),
+	return &MemTable{
+		Table:      NewSkipList(),
+		Immutables: make([]*SkipList, 0),
 	}
-
-	var wg sync.WaitGroup
-
-	go m.ListenSwitchTableEvent(&wg)
-
-	return m
 }
 
-func (m *MemTable) Get(key string) *models.Result {
+// tables returns the active table and a copy of the immutable tables.
+func (m *MemTable) tables() (*SkipList, []*SkipList) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	val, exists := m.Table[key]
+	return m.Table, append([]*SkipList(nil), m.Immutables...)
+}
+
+// Get returns the newest version of key written no later than sequence,
+// looking in the active table and then in the immutable tables, newest first.
+// A key deleted by a range tombstone is reported deleted at the sequence of
+// the tombstone; everything in the SSTables is older than it.
+func (m *MemTable) Get(key string, sequence uint64) *models.Result {
+	table, immutables := m.tables()
+	tables := append(immutables, table)
 
-	if !exists {
-		val, exists = m.ReadOnlyTable[key]
+	var val *MemTableEntry
+	exists, covering := false, uint64(0)
+
+	for i := len(tables) - 1; !exists && i >= 0; i-- {
+		covering = max(covering, iterator.CoveringSequence(tables[i].RangeTombstones(), key, sequence))
+		val, exists = tables[i].Get(key, sequence)
+	}
+
+	if exists && val.Sequence < covering || !exists && covering > 0 {
+		return models.NewDeletedResult(covering)
 	}
 
-	if exists && val.IsTombstone {
-		return models.NewDeletedResult()
+	if exists && (val.IsTombstone || iterator.Expired(val.ExpiresAt, time.Now().UnixNano())) {
+		return models.NewDeletedResult(val.Sequence)
+	}
+	if exists && val.IsMerge {
+		return models.NewMergeOperandResult(val.Sequence)
 	}
 	if exists {
-		return models.NewFoundResult(val.Value)
+		return models.NewFoundResult(val.Value, val.Sequence)
 	}
 
 	return models.NewNotFoundResult()
 }
 
-func (m *MemTable) Put(key string, value string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (m *MemTable) Put(key string, value string, sequence uint64) {
+	m.PutWithExpiry(key, value, 0, sequence)
+}
+
+// PutWithExpiry adds a version of key that reads as deleted from expiresAt,
+// in Unix nanoseconds, on. An expiresAt of 0 never expires.
+func (m *MemTable) PutWithExpiry(key string, value string, expiresAt int64, sequence uint64) {
+	table, _ := m.tables()
 
-	m.Table[key] = NewMemTableEntry(value)
+	entry := NewMemTableEntry(value, sequence)
+	entry.ExpiresAt = expiresAt
+
+	table.Put(key, entry)
 }
 
-func (m *MemTable) Delete(key string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// Merge records operand, to be combined with the older versions of key by
+// the merge operator.
+func (m *MemTable) Merge(key string, operand string, sequence uint64) {
+	table, _ := m.tables()
+
+	entry := NewMemTableEntry(operand, sequence)
+	entry.IsMerge = true
 
-	m.Table[key] = NewMemTableEntry("")
-	m.Table[key].IsTombstone = true
+	table.Put(key, entry)
 }
 
-func (m *MemTable) Size() int {
-	return len(m.Table)
+// DeleteRange records a range tombstone deleting the keys in [start, end)
+// written before sequence.
+func (m *MemTable) DeleteRange(start string, end string, sequence uint64) {
+	table, _ := m.tables()
+
+	table.AddRangeTombstone(iterator.RangeTombstone{Start: start, End: end, Sequence: sequence})
 }
 
-func (m *MemTable) ListenSwitchTableEvent(wg *sync.WaitGroup) {
+func (m *MemTable) Delete(key string, sequence uint64) {
+	table, _ := m.tables()
 
-	defer wg.Done()
+	entry := NewMemTableEntry("", sequence)
+	entry.IsTombstone = true
 
-	exitSignal := make(chan os.Signal, 1)
+	table.Put(key, entry)
+}
+
+// Size returns the approximate memory used by the active table.
+func (m *MemTable) Size() int {
+	table, _ := m.tables()
+
+	return table.ApproximateSize()
+}
 
-	for {
-		select {
-		case switchevent := <-m.sharedChannel.SwitchMemtableEvent:
-			if switchevent < 0 {
-				continue
-			}
+// NumberOfImmutables returns how many tables are waiting for flush.
+func (m *MemTable) NumberOfImmutables() int {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
 
-			m.swtichMemtable()
+	return len(m.Immutables)
+}
 
-			m.sharedChannel.FlushMemtableEvent <- 1
-		case <-exitSignal:
-			return
-		}
+// OldestImmutable returns the table to flush next, or nil when there is none.
+func (m *MemTable) OldestImmutable() *SkipList {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if len(m.Immutables) == 0 {
+		return nil
 	}
+
+	return m.Immutables[0]
 }
 
-func (m *MemTable) swtichMemtable() {
+// SwitchMemtable freezes the active table into the immutable queue once it
+// reaches MemTableConfig.MaxSize and reports whether it did so. The active
+// table keeps growing while the queue is full.
+func (m *MemTable) SwitchMemtable() bool {
+	return m.swtichMemtable()
+}
+
+func (m *MemTable) swtichMemtable() bool {
 
 	config := configs.GetStorageEngineConfig()
 
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if m.Size() < config.MemTableConfig.MaxCapacity || m.ReadOnlyTable != nil {
-		return
+	if m.Table.ApproximateSize() < config.MemTableConfig.MaxSize || len(m.Immutables) >= config.MemTableConfig.MaxImmutableMemtables {
+		return false
 	}
 
-	m.ReadOnlyTable = m.Table
-	m.Table = make(map[string]*MemTableEntry)
+	m.Immutables = append(m.Immutables, m.Table)
+	m.Table = NewSkipList()
+
+	return true
 }
 
-func (m *MemTable) ClearReadOnlyMemtable() {
-	m.ReadOnlyTable = nil
+// RemoveOldestImmutable drops the oldest immutable table once it is flushed.
+func (m *MemTable) RemoveOldestImmutable() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if len(m.Immutables) > 0 {
+		m.Immutables = m.Immutables[1:]
+	}
 }
@@ -0,0 +1,21 @@
+package memtable
+
+import (
+	"pkvstore/internal/storageengine/iterator"
+)
+
+// NewIterators returns iterators over the immutable and the active tables,
+// oldest first, along with the range tombstones of those tables.
+func (m *MemTable) NewIterators() ([]iterator.InternalIterator, []iterator.RangeTombstone) {
+	table, immutables := m.tables()
+
+	iterators := make([]iterator.InternalIterator, 0, len(immutables)+1)
+	tombstones := make([]iterator.RangeTombstone, 0)
+
+	for _, skipList := range append(immutables, table) {
+		iterators = append(iterators, skipList.NewIterator())
+		tombstones = append(tombstones, skipList.RangeTombstones()...)
+	}
+
+	return iterators, tombstones
+}
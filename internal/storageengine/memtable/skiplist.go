@@ -0,0 +1,244 @@
+package memtable
+
+import (
+	"math/rand"
+	"pkvstore/internal/storageengine/iterator"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	maxHeight = 12
+	branching = 4
+
+	// nodes and their next pointers are carved out of chunks of this many
+	// elements, so the garbage collector tracks a few large allocations
+	// instead of one per key
+	arenaChunkSize = 1024
+
+	// memory a key costs beyond its bytes: the node, its entry and, per
+	// level, one next pointer
+	nodeOverhead    = int64(unsafe.Sizeof(skipListNode{}) + unsafe.Sizeof(MemTableEntry{}))
+	pointerOverhead = int64(unsafe.Sizeof(atomic.Pointer[skipListNode]{}))
+
+	rangeTombstoneOverhead = int64(unsafe.Sizeof(iterator.RangeTombstone{}))
+)
+
+type skipListNode struct {
+	key   string
+	entry *MemTableEntry
+	next  []atomic.Pointer[skipListNode]
+}
+
+// arena hands out nodes from preallocated chunks. It is only used by the
+// single writer of a SkipList.
+type arena struct {
+	nodes    []skipListNode
+	pointers []atomic.Pointer[skipListNode]
+}
+
+func (a *arena) newNode(key string, entry *MemTableEntry, height int) *skipListNode {
+	if len(a.nodes) == 0 {
+		a.nodes = make([]skipListNode, arenaChunkSize)
+	}
+
+	if len(a.pointers) < height {
+		a.pointers = make([]atomic.Pointer[skipListNode], arenaChunkSize)
+	}
+
+	node := &a.nodes[0]
+	a.nodes = a.nodes[1:]
+
+	node.key = key
+	node.entry = entry
+	node.next = a.pointers[:height:height]
+	a.pointers = a.pointers[height:]
+
+	return node
+}
+
+// SkipList holds memtable entries in internal key order: by key and then by
+// sequence number, newest first. Every write adds a version. Writes must be
+// serialized by the caller; reads and iteration need no lock and may run
+// concurrently with a write, because nodes are published with atomic stores
+// only after they are fully linked below.
+type SkipList struct {
+	head   *skipListNode
+	height atomic.Int32
+	length atomic.Int64
+	bytes  atomic.Int64
+	arena  arena
+	random *rand.Rand
+
+	// range tombstones are few, so they are kept apart from the keys in a
+	// slice that is copied on every addition
+	rangeTombstones atomic.Pointer[[]iterator.RangeTombstone]
+}
+
+func NewSkipList() *SkipList {
+	skipList := &SkipList{
+		random: rand.New(rand.NewSource(rand.Int63())),
+	}
+
+	skipList.head = skipList.arena.newNode("", nil, maxHeight)
+	skipList.height.Store(1)
+
+	return skipList
+}
+
+func (s *SkipList) randomHeight() int {
+	height := 1
+
+	for height < maxHeight && s.random.Intn(branching) == 0 {
+		height++
+	}
+
+	return height
+}
+
+// before reports whether the node comes before the version (key, sequence).
+func (node *skipListNode) before(key string, sequence uint64) bool {
+	return iterator.CompareInternalKey(node.key, node.entry.Sequence, key, sequence) < 0
+}
+
+// findGreaterOrEqual returns the first node not before (key, sequence) and,
+// when previous is not nil, fills it with the last node before it per level.
+func (s *SkipList) findGreaterOrEqual(key string, sequence uint64, previous []*skipListNode) *skipListNode {
+	node := s.head
+
+	for level := int(s.height.Load()) - 1; level >= 0; level-- {
+		next := node.next[level].Load()
+
+		for next != nil && next.before(key, sequence) {
+			node = next
+			next = node.next[level].Load()
+		}
+
+		if previous != nil {
+			previous[level] = node
+		}
+
+		if level == 0 {
+			return next
+		}
+	}
+
+	return nil
+}
+
+// Get returns the newest version of key written no later than sequence.
+func (s *SkipList) Get(key string, sequence uint64) (*MemTableEntry, bool) {
+	node := s.findGreaterOrEqual(key, sequence, nil)
+
+	if node == nil || node.key != key {
+		return nil, false
+	}
+
+	return node.entry, true
+}
+
+// Put adds a version of key. Its sequence number must not be in the list yet.
+func (s *SkipList) Put(key string, entry *MemTableEntry) {
+	previous := make([]*skipListNode, maxHeight)
+	s.findGreaterOrEqual(key, entry.Sequence, previous)
+
+	height := s.randomHeight()
+
+	if currentHeight := int(s.height.Load()); height > currentHeight {
+		for level := currentHeight; level < height; level++ {
+			previous[level] = s.head
+		}
+
+		// readers that see the new height before the node only walk a few
+		// more nil links in head
+		s.height.Store(int32(height))
+	}
+
+	node := s.arena.newNode(key, entry, height)
+
+	for level := 0; level < height; level++ {
+		node.next[level].Store(previous[level].next[level].Load())
+		previous[level].next[level].Store(node)
+	}
+
+	s.length.Add(1)
+	s.bytes.Add(int64(len(key)+len(entry.Value)) + nodeOverhead + int64(height)*pointerOverhead)
+}
+
+// AddRangeTombstone records a range tombstone. Like Put, it must be
+// serialized with the other writes.
+func (s *SkipList) AddRangeTombstone(tombstone iterator.RangeTombstone) {
+	tombstones := append(s.RangeTombstones(), tombstone)
+
+	s.rangeTombstones.Store(&tombstones)
+	s.bytes.Add(int64(len(tombstone.Start)+len(tombstone.End)) + rangeTombstoneOverhead)
+}
+
+// RangeTombstones returns the range tombstones of the list, oldest first. The
+// slice must not be modified.
+func (s *SkipList) RangeTombstones() []iterator.RangeTombstone {
+	tombstones := s.rangeTombstones.Load()
+
+	if tombstones == nil {
+		return nil
+	}
+
+	return *tombstones
+}
+
+// Len returns the number of versions in the list.
+func (s *SkipList) Len() int {
+	return int(s.length.Load())
+}
+
+// ApproximateSize returns the memory taken by the keys and values in the list
+// and by the nodes holding them.
+func (s *SkipList) ApproximateSize() int {
+	return int(s.bytes.Load())
+}
+
+// NewIterator returns an unpositioned iterator over the list. Keys written
+// while it is open may or may not be returned.
+func (s *SkipList) NewIterator() iterator.InternalIterator {
+	return &skipListIterator{
+		list: s,
+	}
+}
+
+type skipListIterator struct {
+	list *SkipList
+	node *skipListNode
+}
+
+func (it *skipListIterator) SeekToFirst() {
+	it.node = it.list.head.next[0].Load()
+}
+
+func (it *skipListIterator) Seek(key string) {
+	it.node = it.list.findGreaterOrEqual(key, iterator.MaxSequence, nil)
+}
+
+func (it *skipListIterator) Next() {
+	it.node = it.node.next[0].Load()
+}
+
+func (it *skipListIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *skipListIterator) Entry() *iterator.Entry {
+	entry := it.node.entry
+
+	return &iterator.Entry{
+		Key:         it.node.key,
+		Sequence:    entry.Sequence,
+		Value:       entry.Value,
+		IsTombstone: entry.IsTombstone,
+		IsMerge:     entry.IsMerge,
+		ExpiresAt:   entry.ExpiresAt,
+	}
+}
+
+func (it *skipListIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package memtable
+
+import (
+	"fmt"
+	"pkvstore/internal/storageengine/iterator"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type version struct {
+	key      string
+	sequence uint64
+}
+
+func TestSkipListGet(t *testing.T) {
+	list := NewSkipList()
+	list.Put("b", NewMemTableEntry("b1", 1))
+	list.Put("a", NewMemTableEntry("a2", 2))
+	list.Put("b", NewMemTableEntry("b3", 3))
+	list.Put("c", &MemTableEntry{Sequence: 4, IsTombstone: true})
+
+	tests := []struct {
+		key       string
+		sequence  uint64
+		wantValue string
+		wantFound bool
+	}{
+		{key: "a", sequence: iterator.MaxSequence, wantValue: "a2", wantFound: true},
+		{key: "a", sequence: 1, wantFound: false},
+		{key: "b", sequence: iterator.MaxSequence, wantValue: "b3", wantFound: true},
+		{key: "b", sequence: 2, wantValue: "b1", wantFound: true},
+		{key: "b", sequence: 0, wantFound: false},
+		{key: "c", sequence: iterator.MaxSequence, wantFound: true},
+		{key: "d", sequence: iterator.MaxSequence, wantFound: false},
+		{key: "", sequence: iterator.MaxSequence, wantFound: false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s@%d", test.key, test.sequence), func(t *testing.T) {
+			entry, found := list.Get(test.key, test.sequence)
+
+			if found != test.wantFound {
+				t.Fatalf("found %v, want %v", found, test.wantFound)
+			}
+
+			if found && entry.Value != test.wantValue {
+				t.Errorf("got %q, want %q", entry.Value, test.wantValue)
+			}
+		})
+	}
+}
+
+func TestSkipListIteratorOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		puts []version
+		seek string // "" for SeekToFirst
+		want []version
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "keys ascending, versions newest first",
+			puts: []version{{"b", 1}, {"a", 2}, {"b", 3}, {"c", 4}, {"a", 5}},
+			want: []version{{"a", 5}, {"a", 2}, {"b", 3}, {"b", 1}, {"c", 4}},
+		},
+		{
+			name: "seek to the newest version of a key",
+			puts: []version{{"b", 1}, {"a", 2}, {"b", 3}, {"c", 4}},
+			seek: "b",
+			want: []version{{"b", 3}, {"b", 1}, {"c", 4}},
+		},
+		{
+			name: "seek between keys",
+			puts: []version{{"a", 1}, {"c", 2}},
+			seek: "b",
+			want: []version{{"c", 2}},
+		},
+		{
+			name: "seek past the last key",
+			puts: []version{{"a", 1}},
+			seek: "z",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			list := NewSkipList()
+
+			for _, put := range test.puts {
+				list.Put(put.key, NewMemTableEntry("", put.sequence))
+			}
+
+			it := list.NewIterator()
+
+			if test.seek == "" {
+				it.SeekToFirst()
+			} else {
+				it.Seek(test.seek)
+			}
+
+			var got []version
+
+			for ; it.Valid(); it.Next() {
+				got = append(got, version{it.Entry().Key, it.Entry().Sequence})
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			if list.Len() != len(test.puts) {
+				t.Errorf("Len is %d, want %d", list.Len(), len(test.puts))
+			}
+		})
+	}
+}
+
+func TestSkipListApproximateSizeGrows(t *testing.T) {
+	list := NewSkipList()
+	size := list.ApproximateSize()
+
+	for i := 0; i < 100; i++ {
+		list.Put(fmt.Sprint("key", i), NewMemTableEntry("value", uint64(i+1)))
+
+		if list.ApproximateSize() <= size {
+			t.Fatalf("size did not grow after put %d", i)
+		}
+
+		size = list.ApproximateSize()
+	}
+}
+
+// A single writer and concurrent readers must never see a partly linked node
+// or versions out of order.
+func TestSkipListConcurrentReads(t *testing.T) {
+	const numberOfKeys = 2000
+
+	list := NewSkipList()
+
+	var wg sync.WaitGroup
+
+	for reader := 0; reader < 4; reader++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for round := 0; round < 20; round++ {
+				it := list.NewIterator()
+				previous := (*iterator.Entry)(nil)
+
+				for it.SeekToFirst(); it.Valid(); it.Next() {
+					entry := it.Entry()
+
+					if previous != nil && iterator.CompareInternalKey(previous.Key, previous.Sequence, entry.Key, entry.Sequence) >= 0 {
+						t.Errorf("%s@%d comes after %s@%d", entry.Key, entry.Sequence, previous.Key, previous.Sequence)
+						return
+					}
+
+					previous = entry
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < numberOfKeys; i++ {
+		list.Put(fmt.Sprintf("key%05d", (i*7919)%numberOfKeys), NewMemTableEntry("value", uint64(i+1)))
+	}
+
+	wg.Wait()
+
+	if list.Len() != numberOfKeys {
+		t.Errorf("Len is %d, want %d", list.Len(), numberOfKeys)
+	}
+}
@@ -0,0 +1,125 @@
+package mergeoperator
+
+import (
+	"errors"
+	"strconv"
+)
+
+var ErrNoMergeOperator = errors.New("no merge operator is configured")
+
+// MergeOperator combines the operands written by Merge with the value of a
+// key. Reads combine them lazily and compactions eagerly, so an operator
+// must give the same result however its operands are grouped.
+type MergeOperator interface {
+	Name() string
+
+	// FullMerge applies operands, oldest first, to the existing value of key,
+	// which is absent when exists is false.
+	FullMerge(key string, existing string, exists bool, operands []string) (string, error)
+
+	// PartialMerge combines two adjacent operands into one with the same
+	// effect, or reports false when it cannot.
+	PartialMerge(key string, older string, newer string) (string, bool)
+}
+
+// Int64Add treats values and operands as decimal int64 and adds them up. A
+// missing key counts as 0.
+type Int64Add struct{}
+
+func (Int64Add) Name() string {
+	return "int64add"
+}
+
+func (Int64Add) FullMerge(key string, existing string, exists bool, operands []string) (string, error) {
+	sum := int64(0)
+
+	if exists {
+		value, err := strconv.ParseInt(existing, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		sum = value
+	}
+
+	for _, operand := range operands {
+		value, err := strconv.ParseInt(operand, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		sum += value
+	}
+
+	return strconv.FormatInt(sum, 10), nil
+}
+
+func (operator Int64Add) PartialMerge(key string, older string, newer string) (string, bool) {
+	sum, err := operator.FullMerge(key, older, true, []string{newer})
+
+	return sum, err == nil
+}
+
+// StringAppend appends operands to the value, separated by Delimiter.
+type StringAppend struct {
+	Delimiter string
+}
+
+func (StringAppend) Name() string {
+	return "stringappend"
+}
+
+func (operator StringAppend) FullMerge(key string, existing string, exists bool, operands []string) (string, error) {
+	result := existing
+
+	for _, operand := range operands {
+		if exists {
+			result += operator.Delimiter
+		}
+		result += operand
+		exists = true
+	}
+
+	return result, nil
+}
+
+func (operator StringAppend) PartialMerge(key string, older string, newer string) (string, bool) {
+	return older + operator.Delimiter + newer, true
+}
+
+// Max keeps the largest of the value and the operands, compared as decimal
+// int64.
+type Max struct{}
+
+func (Max) Name() string {
+	return "max"
+}
+
+func (Max) FullMerge(key string, existing string, exists bool, operands []string) (string, error) {
+	var max int64
+
+	if exists {
+		value, err := strconv.ParseInt(existing, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		max = value
+	}
+
+	for _, operand := range operands {
+		value, err := strconv.ParseInt(operand, 10, 64)
+		if err != nil {
+			return "", err
+		}
+		if !exists || value > max {
+			max = value
+		}
+		exists = true
+	}
+
+	return strconv.FormatInt(max, 10), nil
+}
+
+func (operator Max) PartialMerge(key string, older string, newer string) (string, bool) {
+	max, err := operator.FullMerge(key, older, true, []string{newer})
+
+	return max, err == nil
+}
@@ -0,0 +1,90 @@
+package mergeoperator
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestFullMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator MergeOperator
+		existing string
+		exists   bool
+		operands []string
+		want     string
+		wantErr  error
+	}{
+		{name: "add to a value", operator: Int64Add{}, existing: "10", exists: true, operands: []string{"5", "-3"}, want: "12"},
+		{name: "add without a value", operator: Int64Add{}, operands: []string{"2", "3"}, want: "5"},
+		{name: "add to text", operator: Int64Add{}, existing: "text", exists: true, operands: []string{"1"}, wantErr: strconv.ErrSyntax},
+		{name: "add text", operator: Int64Add{}, operands: []string{"1", "text"}, wantErr: strconv.ErrSyntax},
+		{name: "append to a value", operator: StringAppend{Delimiter: ","}, existing: "a", exists: true, operands: []string{"b", "c"}, want: "a,b,c"},
+		{name: "append without a value", operator: StringAppend{Delimiter: ","}, operands: []string{"b", "c"}, want: "b,c"},
+		{name: "append to an empty value", operator: StringAppend{Delimiter: ","}, existing: "", exists: true, operands: []string{"b"}, want: ",b"},
+		{name: "max over a value", operator: Max{}, existing: "7", exists: true, operands: []string{"3", "9", "4"}, want: "9"},
+		{name: "max below a value", operator: Max{}, existing: "7", exists: true, operands: []string{"3"}, want: "7"},
+		{name: "max without a value", operator: Max{}, operands: []string{"-5", "-2"}, want: "-2"},
+		{name: "max of text", operator: Max{}, operands: []string{"text"}, wantErr: strconv.ErrSyntax},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.operator.FullMerge("key", test.existing, test.exists, test.operands)
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+
+			if err == nil && got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Compactions combine operands pairwise before the value is known, so folding
+// them with PartialMerge first must give the same result as applying them all.
+func TestPartialMergeMatchesFullMerge(t *testing.T) {
+	tests := []struct {
+		operator MergeOperator
+		existing string
+		operands []string
+	}{
+		{operator: Int64Add{}, existing: "10", operands: []string{"5", "-3", "8"}},
+		{operator: StringAppend{Delimiter: ","}, existing: "a", operands: []string{"b", "c", "d"}},
+		{operator: Max{}, existing: "7", operands: []string{"3", "9", "4"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.operator.Name(), func(t *testing.T) {
+			want, err := test.operator.FullMerge("key", test.existing, true, test.operands)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the newer operands folded into one
+			combined := test.operands[1]
+			for _, operand := range test.operands[2:] {
+				var ok bool
+				if combined, ok = test.operator.PartialMerge("key", combined, operand); !ok {
+					t.Fatalf("PartialMerge(%q, %q) failed", combined, operand)
+				}
+			}
+
+			got, err := test.operator.FullMerge("key", test.existing, true, []string{test.operands[0], combined})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+
+	if _, ok := (Int64Add{}).PartialMerge("key", "1", "text"); ok {
+		t.Error("Int64Add combined a non-numeric operand")
+	}
+}
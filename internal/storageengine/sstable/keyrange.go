@@ -0,0 +1,83 @@
+package sstable
+
+import "sort"
+
+// KeyRange is the half-open range of keys [Start, Limit). An empty Limit
+// leaves the range unbounded above.
+type KeyRange struct {
+	Start string
+	Limit string
+}
+
+func (keyRange KeyRange) Contains(key string) bool {
+	return keyRange.Start <= key && (keyRange.Limit == "" || key < keyRange.Limit)
+}
+
+func (keyRange KeyRange) Overlaps(other KeyRange) bool {
+	return (keyRange.Limit == "" || other.Start < keyRange.Limit) && (other.Limit == "" || keyRange.Start < other.Limit)
+}
+
+// Union returns the smallest range holding both ranges.
+func (keyRange KeyRange) Union(other KeyRange) KeyRange {
+	union := KeyRange{Start: min(keyRange.Start, other.Start)}
+
+	if keyRange.Limit != "" && other.Limit != "" {
+		union.Limit = max(keyRange.Limit, other.Limit)
+	}
+
+	return union
+}
+
+// KeyRange returns the range holding the keys of the table and the keys its
+// range tombstones cover. A table holding neither spans every key.
+func (sst *SSTable) KeyRange() KeyRange {
+	keyRange, empty := KeyRange{}, true
+
+	if len(sst.Blocks) > 0 {
+		keyRange, empty = KeyRange{Start: sst.Blocks[0].Anchor.Key, Limit: sst.LargestKey + "\x00"}, false
+	}
+
+	for _, tombstone := range sst.RangeTombstones {
+		tombstoneRange := KeyRange{Start: tombstone.Start, Limit: tombstone.End}
+
+		if empty {
+			keyRange, empty = tombstoneRange, false
+		} else {
+			keyRange = keyRange.Union(tombstoneRange)
+		}
+	}
+
+	return keyRange
+}
+
+// SortByKeyRange sorts tables by the start of their key ranges and reports
+// whether the ranges are disjoint, so that every key belongs to at most one
+// of the tables.
+func SortByKeyRange(tables []*SSTable) bool {
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].KeyRange().Start < tables[j].KeyRange().Start
+	})
+
+	for i := 1; i < len(tables); i++ {
+		if tables[i-1].KeyRange().Overlaps(tables[i].KeyRange()) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FindByKey returns the index of the table whose key range contains key in
+// tables sorted by disjoint key ranges, or -1 when there is none.
+func FindByKey(tables []*SSTable, key string) int {
+	i := sort.Search(len(tables), func(i int) bool {
+		limit := tables[i].KeyRange().Limit
+		return limit == "" || key < limit
+	})
+
+	if i < len(tables) && tables[i].KeyRange().Contains(key) {
+		return i
+	}
+
+	return -1
+}
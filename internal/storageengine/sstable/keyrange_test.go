@@ -0,0 +1,76 @@
+package sstable
+
+import (
+	"pkvstore/internal/storageengine/iterator"
+	"testing"
+)
+
+func TestKeyRange(t *testing.T) {
+	tests := []struct {
+		name            string
+		keys            []string
+		rangeTombstones []iterator.RangeTombstone
+		want            KeyRange
+	}{
+		{name: "keys", keys: []string{"b", "d"}, want: KeyRange{Start: "b", Limit: "d\x00"}},
+		{
+			name:            "range tombstone past the keys",
+			keys:            []string{"b", "d"},
+			rangeTombstones: []iterator.RangeTombstone{{Start: "a", End: "c"}, {Start: "c", End: "f"}},
+			want:            KeyRange{Start: "a", Limit: "f"},
+		},
+		{
+			name:            "unbounded range tombstone",
+			keys:            []string{"b"},
+			rangeTombstones: []iterator.RangeTombstone{{Start: "c"}},
+			want:            KeyRange{Start: "b"},
+		},
+		{
+			name:            "only range tombstones",
+			rangeTombstones: []iterator.RangeTombstone{{Start: "c", End: "e"}},
+			want:            KeyRange{Start: "c", Limit: "e"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := keyRangeTable(test.keys, test.rangeTombstones).KeyRange(); got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSortByKeyRange(t *testing.T) {
+	c, a, b := keyRangeTable([]string{"c", "d"}, nil), keyRangeTable([]string{"a"}, nil), keyRangeTable([]string{"b"}, nil)
+
+	tables := []*SSTable{c, a, b}
+	if !SortByKeyRange(tables) {
+		t.Fatal("disjoint tables reported as overlapping")
+	}
+
+	if tables[0] != a || tables[1] != b || tables[2] != c {
+		t.Error("tables are not sorted by key range")
+	}
+
+	for key, want := range map[string]int{"a": 0, "b": 1, "c": 2, "cc": 2, "d": 2, "e": -1, "0": -1} {
+		if got := FindByKey(tables, key); got != want {
+			t.Errorf("FindByKey(%q) = %d, want %d", key, got, want)
+		}
+	}
+
+	if SortByKeyRange([]*SSTable{keyRangeTable([]string{"a", "c"}, nil), b}) {
+		t.Error("overlapping tables reported as disjoint")
+	}
+}
+
+func keyRangeTable(keys []string, rangeTombstones []iterator.RangeTombstone) *SSTable {
+	table := OpenSSTable(0, 10)
+
+	for _, key := range keys {
+		table.AddEntry(NewSSTableEntry(key, 1, "value", false))
+	}
+	table.RangeTombstones = rangeTombstones
+
+	return table.CompleteSSTableCreation()
+}
@@ -1,10 +1,16 @@
 package sstable
 
 import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
 	"pkvstore/internal/core"
 	"pkvstore/internal/models"
 	"pkvstore/internal/storageengine/configs"
-	"strconv"
+	"pkvstore/internal/storageengine/iterator"
+	"sort"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,39 +24,63 @@ type SSTableHeader struct {
 	sealed        bool
 }
 
-// SSTableEntry represents an entry in an SSTable.
+// SSTableEntry represents one version of a key in an SSTable. Entries are
+// sorted by key and then by sequence number, newest first.
 type SSTableEntry struct {
 	Key         string
+	Sequence    uint64
 	Value       string
 	IsTombstone bool
+	IsMerge     bool  // Value is a merge operand
+	ExpiresAt   int64 // Unix time in nanoseconds after which the value reads as deleted, 0 for never
 }
 
-// SSTableBlock represents a block in an SSTable.
+// SSTableBlock represents a block in an SSTable. Once the table is on disk,
+// Entries is nil and the block is read from Offset when needed.
 type SSTableBlock struct {
 	Sequence int
 	Anchor   *SSTableEntry
 	Entries  []*SSTableEntry
 	Filter   *core.BloomFilter
+	Offset   uint64
+	Size     uint32
 }
 
-// SSTableFooter represents the footer of an SSTable.
+// SSTableFooter represents the fixed-size footer of an SSTable file.
 type SSTableFooter struct {
-	Checksum uint32
+	HeaderSize    uint32
+	FilterOffset  uint64
+	FilterSize    uint32
+	IndexOffset   uint64
+	IndexSize     uint32
+	FormatVersion uint32
+	Checksum      uint32
 }
 
 // SSTable represents a sorted string table.
 type SSTable struct {
-	Header *SSTableHeader
-	Blocks []*SSTableBlock
-	Footer *SSTableFooter
-	Filter *core.BloomFilter
+	FileNumber uint64
+	Header     *SSTableHeader
+	Blocks     []*SSTableBlock
+	Footer     *SSTableFooter
+	Filter     *core.BloomFilter
+	// kept in memory with the index; they may cover keys of older tables
+	RangeTombstones []iterator.RangeTombstone
+	LargestKey      string
+
+	// bytes of keys and values added while the table is built
+	dataSize uint64
+
+	file     *os.File
+	refs     atomic.Int32
+	obsolete atomic.Bool
 }
 
 // newSSTableHeader creates a new SSTableHeader.
 func newSSTableHeader(level uint8, version string, blockSize uint32, numberEntries uint) *SSTableHeader {
 	return &SSTableHeader{
 		Level:         level,
-		Timestamp:     time.Now().Unix(),
+		Timestamp:     time.Now().UnixNano(),
 		Version:       version,
 		BlockSize:     blockSize,
 		NumberEntries: numberEntries,
@@ -59,9 +89,10 @@ func newSSTableHeader(level uint8, version string, blockSize uint32, numberEntri
 }
 
 // NewSSTableEntry creates a new SSTableEntry.
-func NewSSTableEntry(key string, value string, isTombstone bool) *SSTableEntry {
+func NewSSTableEntry(key string, sequence uint64, value string, isTombstone bool) *SSTableEntry {
 	return &SSTableEntry{
 		Key:         key,
+		Sequence:    sequence,
 		Value:       value,
 		IsTombstone: isTombstone,
 	}
@@ -89,21 +120,27 @@ func (sstblock *SSTableBlock) addEntry(entry *SSTableEntry) {
 func newSSTable(level uint8, numberOfEntries uint) *SSTable {
 	configs := configs.GetStorageEngineConfig()
 
-	return &SSTable{
+	sstable := &SSTable{
 		Header: newSSTableHeader(level, configs.SSTableConfig.Version, uint32(configs.SSTableConfig.BlockCapacity), numberOfEntries),
 		Blocks: make([]*SSTableBlock, 0),
 		Filter: core.NewBloomFilter(numberOfEntries, configs.SSTableConfig.FilterFalsePositive, "optimal"),
 	}
+	sstable.refs.Store(1)
+
+	return sstable
 }
 
 // region
 func OpenSSTable(level uint8, NumberEntries uint) *SSTable {
 	configs := configs.GetStorageEngineConfig()
-	return &SSTable{
+	sstable := &SSTable{
 		Header: newSSTableHeader(level, configs.SSTableConfig.Version, uint32(configs.SSTableConfig.BlockCapacity), 0),
 		Blocks: make([]*SSTableBlock, 0),
-		Filter: core.NewBloomFilter(NumberEntries/10, configs.SSTableConfig.FilterFalsePositive, "optimal"),
+		Filter: core.NewBloomFilter(max(NumberEntries/10, 1), configs.SSTableConfig.FilterFalsePositive, "optimal"),
 	}
+	sstable.refs.Store(1)
+
+	return sstable
 }
 
 func (sstable *SSTable) AddEntry(newSSTableEntry *SSTableEntry) {
@@ -122,14 +159,39 @@ func (sstable *SSTable) CompleteSSTableCreation() *SSTable {
 
 //end region
 
-// CreateSSTable creates an SSTable from SSTableEntries.
-func CreateSSTable(sstableEntries []*SSTableEntry, level uint8) *SSTable {
-	newSSTable := newSSTable(level, uint(len(sstableEntries)))
+// CreateSSTable creates an SSTable from the at most numberOfEntries entries of
+// a sorted iterator, such as a memtable's. Of the versions of a key, only the
+// newest one and those still visible to one of the snapshots, given as
+// ascending sequence numbers, are kept, along with the versions merge
+// operands apply to. The range tombstones are stored with the table.
+func CreateSSTable(entries iterator.InternalIterator, rangeTombstones []iterator.RangeTombstone, numberOfEntries uint, level uint8, snapshots []uint64) *SSTable {
+	newSSTable := newSSTable(level, numberOfEntries)
+	newSSTable.RangeTombstones = rangeTombstones
+	numberOfVersions := uint(0)
+	lastKey, lastStripe, lastIsMerge := "", 0, false
+
+	for entries.SeekToFirst(); entries.Valid(); entries.Next() {
+		entry := entries.Entry()
+		stripe := iterator.SnapshotStripe(snapshots, entry.Sequence)
+
+		// merge operands need the older versions they apply to, up to the
+		// first value or tombstone
+		if numberOfVersions > 0 && entry.Key == lastKey && stripe == lastStripe && !lastIsMerge {
+			continue
+		}
+
+		sstableEntry := NewSSTableEntry(entry.Key, entry.Sequence, entry.Value, entry.IsTombstone)
+		sstableEntry.IsMerge = entry.IsMerge
+		sstableEntry.ExpiresAt = entry.ExpiresAt
 
-	for _, sstableEntry := range sstableEntries {
 		newSSTable.addEntry(sstableEntry)
+		numberOfVersions++
+		lastIsMerge = entry.IsMerge
+		lastKey, lastStripe = entry.Key, stripe
 	}
 
+	newSSTable.Header.NumberEntries = numberOfVersions
+
 	return newSSTable
 }
 
@@ -152,6 +214,22 @@ func (sstable *SSTable) addEntry(newSSTableEntry *SSTableEntry) {
 	lastBlock.addEntry(newSSTableEntry)
 	lastBlock.Filter.Add([]byte(newSSTableEntry.Key))
 	sstable.Filter.Add([]byte(newSSTableEntry.Key))
+	sstable.LargestKey = newSSTableEntry.Key
+	sstable.dataSize += uint64(len(newSSTableEntry.Key) + len(newSSTableEntry.Value))
+}
+
+// DataSize returns the bytes of keys and values added to a table being built.
+func (sstable *SSTable) DataSize() uint64 {
+	return sstable.dataSize
+}
+
+// Size returns the size of the table file once it is written.
+func (sstable *SSTable) Size() uint64 {
+	if sstable.Footer == nil {
+		return 0
+	}
+
+	return sstable.Footer.IndexOffset + uint64(sstable.Footer.IndexSize) + checksumSize + footerSize
 }
 
 // DoesNotExist checks if a key does not exist in the SSTable.
@@ -159,58 +237,98 @@ func (s *SSTable) DoesNotExist(key string) bool {
 	return s.Filter.DoesNotExist([]byte(key))
 }
 
-// ReadFromSSTable reads a key from the SSTable.
-func (s *SSTable) ReadFromSSTable(key string) *models.Result {
+// ReadFromSSTable reads the newest version of key written no later than
+// sequence. It usually loads one block from disk; versions of the key that
+// spill into the following blocks are read only when the older ones are
+// needed. It fails when a block cannot be read.
+func (s *SSTable) ReadFromSSTable(key string, sequence uint64) (*models.Result, error) {
 
-	lastSmallerOrEqualBlock := s.getLastSmallerBlock(key)
+	blockID := s.getLastSmallerBlock(key, sequence)
 
-	if lastSmallerOrEqualBlock == nil || lastSmallerOrEqualBlock.Filter.DoesNotExist([]byte(key)) {
-		return models.NewNotFoundResult()
+	// before the first anchor only when the first block starts with an
+	// older version of key
+	if blockID < 0 {
+		blockID = 0
 	}
 
-	for _, entry := range lastSmallerOrEqualBlock.Entries {
-		if entry.Key == key {
-			if entry.IsTombstone {
-				return models.NewDeletedResult()
+	for ; blockID < len(s.Blocks) && s.Blocks[blockID].Anchor.Key <= key; blockID++ {
+		block := s.Blocks[blockID]
+
+		if block.Filter.DoesNotExist([]byte(key)) {
+			continue
+		}
+
+		entries, err := s.blockEntries(block)
+
+		if err != nil {
+			return nil, fmt.Errorf("reading block %d of SSTable %d: %w", blockID, s.FileNumber, err)
+		}
+
+		for _, entry := range entries {
+			if entry.Key > key {
+				return models.NewNotFoundResult(), nil
+			}
+
+			if entry.Key == key && entry.Sequence <= sequence {
+				if entry.IsTombstone || iterator.Expired(entry.ExpiresAt, time.Now().UnixNano()) {
+					return models.NewDeletedResult(entry.Sequence), nil
+				}
+				if entry.IsMerge {
+					return models.NewMergeOperandResult(entry.Sequence), nil
+				}
+				return models.NewFoundResult(entry.Value, entry.Sequence), nil
 			}
-			return models.NewFoundResult(entry.Value)
 		}
 	}
 
-	return models.NewNotFoundResult()
+	return models.NewNotFoundResult(), nil
 }
 
 // similar to lower_bound implementation in c++
 // lower_bound returns equal or greater than key
-// this func return equal or smaller than key
-func (s *SSTable) getLastSmallerBlock(key string) *SSTableBlock {
-
-	low, high := 0, len(s.Blocks)-1
-
-	var lastSmallerOrEqualBlock *SSTableBlock
-
-	for low <= high {
-
-		mid := (low + high) / 2
+// this func returns the last block whose anchor is equal to or before
+// (key, sequence) in internal key order, or -1 when there is none
+func (s *SSTable) getLastSmallerBlock(key string, sequence uint64) int {
+
+	return sort.Search(len(s.Blocks), func(i int) bool {
+		anchor := s.Blocks[i].Anchor
+		return iterator.CompareInternalKey(anchor.Key, anchor.Sequence, key, sequence) > 0
+	}) - 1
+}
 
-		block := s.Blocks[mid]
+// GetFileName returns the file name of the SSTable.
+func (sst *SSTable) GetFileName() string {
+	return filepath.Base(sst.FilePath(""))
+}
 
-		if block.Anchor.Key <= key {
+// Ref keeps the SSTable file open until the matching Unref.
+func (sst *SSTable) Ref() {
+	sst.refs.Add(1)
+}
 
-			lastSmallerOrEqualBlock = block
+// Unref releases a reference. The last reference to an obsolete table closes
+// and deletes its file.
+func (sst *SSTable) Unref() {
+	if sst.refs.Add(-1) > 0 || sst.file == nil {
+		return
+	}
 
-			low = mid + 1
+	name := sst.file.Name()
 
-		} else {
+	if err := sst.file.Close(); err != nil {
+		log.Println("Closing SSTable:", err)
+	}
 
-			high = mid - 1
+	if sst.obsolete.Load() {
+		if err := os.Remove(name); err != nil {
+			log.Println("Removing SSTable:", err)
 		}
 	}
-
-	return lastSmallerOrEqualBlock
 }
 
-// GetFileName returns the file name of the SSTable.
-func (sst *SSTable) GetFileName() string {
-	return strconv.FormatInt(int64(int(sst.Header.Level)), 10) + "-" + strconv.FormatInt(sst.Header.Timestamp, 10)
+// MarkObsolete drops the reference held by the LSM tree, so the file is
+// deleted as soon as no reader uses it.
+func (sst *SSTable) MarkObsolete() {
+	sst.obsolete.Store(true)
+	sst.Unref()
 }
@@ -1,156 +1,677 @@
 package sstable
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
+	"path/filepath"
 	"pkvstore/internal/core"
+	"pkvstore/internal/storageengine/iterator"
+	"strconv"
+	"strings"
 )
 
-const SSTABLE_FOLDER = "/storage/sstable/"
+// An SSTable file is laid out as:
+//
+//	header | data block 1 | ... | data block n | filter block | index block | footer
+//
+// where:
+//
+//	header: level (uint8) | timestamp (int64) | version | block size (uint32) | number of entries (uint64)
+//	data:   number of entries (uint32) | entry 1 | ... | entry m
+//	entry:  key | sequence (uint64) | value | kind (uint8: 0 value, 1 tombstone, 2 merge operand, 3 expiring value) |
+//	        [expires at (int64), for expiring values]
+//	filter: table filter | number of blocks (uint32) | block filter 1 | ... | block filter n
+//	index:  number of blocks (uint32) | per block: anchor key | anchor sequence (uint64) | offset (uint64) | size (uint32) |
+//	        number of range tombstones (uint32) | per range tombstone: start | end | sequence (uint64) |
+//	        largest key
+//	footer: header size (uint32) | filter offset (uint64) | filter size (uint32) | index offset (uint64) |
+//	        index size (uint32) | format version (uint32) | checksum (uint32) | magic (uint64)
+//
+// Every section except the footer is followed by a CRC32C of its contents; the
+// footer checksum covers the footer fields before it. Strings and encoded
+// bloom filters are written as a uint32 length followed by the bytes.
+const (
+	SSTABLE_EXTENSION        = ".sst"
+	TEMP_EXTENSION           = ".tmp"
+	SSTABLE_MAGIC     uint64 = 0x4c4241545353564b // "KVSSTABL"
+	FORMAT_VERSION    uint32 = 1
+
+	footerSize   = 4 + 8 + 4 + 8 + 4 + 4 + 4 + 8
+	checksumSize = 4
+)
 
-func LoadFromFile(filename string) (*SSTable, error) {
+var (
+	ErrCorruptedSSTable   = errors.New("sstable: corrupted file")
+	ErrUnsupportedVersion = errors.New("sstable: unsupported format version")
+)
 
-	file, err := os.Open(SSTABLE_FOLDER + filename)
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// FilePath returns the path of the SSTable file inside directory.
+func (sst *SSTable) FilePath(directory string) string {
+	return filePath(directory, sst.FileNumber)
+}
+
+func filePath(directory string, fileNumber uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%06d%s", fileNumber, SSTABLE_EXTENSION))
+}
+
+// LoadFromFile opens an SSTable file written by WriteToFile. Only the footer,
+// header and index are kept in memory; data blocks are read on demand.
+func LoadFromFile(directory string, fileNumber uint64) (*SSTable, error) {
+
+	file, err := os.Open(filePath(directory, fileNumber))
 
 	if err != nil {
 		return nil, err
 	}
 
-	defer file.Close()
+	sstable, err := loadFromOpenFile(file)
 
-	// Read header
-	var header SSTableHeader
-	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	// Read blocks
-	blocks := make([]*SSTableBlock, 0)
-	tableFilter := core.NewBloomFilter(10000, 0.01, "optimal")
-	for {
-		var blockSize uint32
+	sstable.FileNumber = fileNumber
 
-		if err := binary.Read(file, binary.LittleEndian, &blockSize); err != nil {
-			break // End of file reached
-		}
+	return sstable, nil
+}
 
-		entries, shouldReturn, returnValue, returnValue1 := ReadBlock(blockSize, file)
-		if shouldReturn {
-			return returnValue, returnValue1
-		}
+func loadFromOpenFile(file *os.File) (*SSTable, error) {
+
+	info, err := file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size() < footerSize {
+		return nil, ErrCorruptedSSTable
+	}
+
+	footerData := make([]byte, footerSize)
+
+	if _, err := file.ReadAt(footerData, info.Size()-footerSize); err != nil {
+		return nil, err
+	}
+
+	footer, err := decodeFooter(footerData)
+
+	if err != nil {
+		return nil, err
+	}
+
+	headerData, err := readSection(file, 0, footer.HeaderSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := decodeHeader(bytes.NewReader(headerData))
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexData, err := readSection(file, footer.IndexOffset, footer.IndexSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	filterData, err := readSection(file, footer.FilterOffset, footer.FilterSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sstable := &SSTable{
+		Header: header,
+		Blocks: make([]*SSTableBlock, 0),
+		Footer: footer,
+		file:   file,
+	}
+	sstable.Header.sealed = true
+	sstable.refs.Store(1)
+
+	if err := sstable.decodeIndex(bytes.NewReader(indexData)); err != nil {
+		return nil, err
+	}
+
+	if err := sstable.decodeFilters(bytes.NewReader(filterData)); err != nil {
+		return nil, err
+	}
+
+	return sstable, nil
+}
+
+// BlockEntries returns the entries of the block at blockID.
+func (sstable *SSTable) BlockEntries(blockID int) ([]*SSTableEntry, error) {
+	return sstable.blockEntries(sstable.Blocks[blockID])
+}
+
+func (sstable *SSTable) blockEntries(block *SSTableBlock) ([]*SSTableEntry, error) {
+	if block.Entries != nil {
+		return block.Entries, nil
+	}
+
+	data, err := readSection(sstable.file, block.Offset, block.Size)
+
+	if err != nil {
+		return nil, err
+	}
 
-		// Create a Cuckoo Filter and populate it
-		blockfilter := core.NewBloomFilter(10000, 0.01, "optimal") // Adjust the capacity as needed
-		for _, entry := range entries {
-			blockfilter.Add([]byte(fmt.Sprint(entry.Key)))
-			tableFilter.Add([]byte(fmt.Sprint(entry.Key)))
+	return decodeBlock(bytes.NewReader(data))
+}
+
+// readSection reads size bytes at offset and verifies the checksum after them.
+func readSection(file *os.File, offset uint64, size uint32) ([]byte, error) {
+	data := make([]byte, int(size)+checksumSize)
+
+	if _, err := file.ReadAt(data, int64(offset)); err != nil {
+		if err == io.EOF {
+			return nil, ErrCorruptedSSTable
 		}
+		return nil, err
+	}
 
-		blocks = append(blocks, &SSTableBlock{
-			Entries: entries,
-			Filter:  blockfilter,
-		})
+	contents := data[:size]
+
+	if crc32.Checksum(contents, crcTable) != binary.LittleEndian.Uint32(data[size:]) {
+		return nil, ErrCorruptedSSTable
+	}
+
+	return contents, nil
+}
+
+func decodeFooter(data []byte) (*SSTableFooter, error) {
+	if binary.LittleEndian.Uint64(data[footerSize-8:]) != SSTABLE_MAGIC {
+		return nil, ErrCorruptedSSTable
+	}
+
+	footer := &SSTableFooter{
+		HeaderSize:    binary.LittleEndian.Uint32(data[0:4]),
+		FilterOffset:  binary.LittleEndian.Uint64(data[4:12]),
+		FilterSize:    binary.LittleEndian.Uint32(data[12:16]),
+		IndexOffset:   binary.LittleEndian.Uint64(data[16:24]),
+		IndexSize:     binary.LittleEndian.Uint32(data[24:28]),
+		FormatVersion: binary.LittleEndian.Uint32(data[28:32]),
+		Checksum:      binary.LittleEndian.Uint32(data[32:36]),
+	}
+
+	if crc32.Checksum(data[0:32], crcTable) != footer.Checksum {
+		return nil, ErrCorruptedSSTable
+	}
+
+	if footer.FormatVersion != FORMAT_VERSION {
+		return nil, ErrUnsupportedVersion
 	}
 
-	// Read footer
-	var footer SSTableFooter
-	if err := binary.Read(file, binary.LittleEndian, &footer); err != nil {
+	return footer, nil
+}
+
+func encodeFooter(footer *SSTableFooter) []byte {
+	data := make([]byte, 0, footerSize)
+
+	data = binary.LittleEndian.AppendUint32(data, footer.HeaderSize)
+	data = binary.LittleEndian.AppendUint64(data, footer.FilterOffset)
+	data = binary.LittleEndian.AppendUint32(data, footer.FilterSize)
+	data = binary.LittleEndian.AppendUint64(data, footer.IndexOffset)
+	data = binary.LittleEndian.AppendUint32(data, footer.IndexSize)
+	data = binary.LittleEndian.AppendUint32(data, footer.FormatVersion)
+
+	footer.Checksum = crc32.Checksum(data, crcTable)
+
+	data = binary.LittleEndian.AppendUint32(data, footer.Checksum)
+	data = binary.LittleEndian.AppendUint64(data, SSTABLE_MAGIC)
+
+	return data
+}
+
+func decodeHeader(reader *bytes.Reader) (*SSTableHeader, error) {
+	header := &SSTableHeader{}
+
+	if err := binary.Read(reader, binary.LittleEndian, &header.Level); err != nil {
+		return nil, ErrCorruptedSSTable
+	}
+
+	if err := binary.Read(reader, binary.LittleEndian, &header.Timestamp); err != nil {
+		return nil, ErrCorruptedSSTable
+	}
+
+	version, err := readString(reader)
+	if err != nil {
 		return nil, err
 	}
+	header.Version = version
+
+	if err := binary.Read(reader, binary.LittleEndian, &header.BlockSize); err != nil {
+		return nil, ErrCorruptedSSTable
+	}
 
-	return &SSTable{
-		Header: &header,
-		Blocks: blocks,
-		Footer: &footer,
-		Filter: tableFilter,
-	}, nil
+	var numberEntries uint64
+	if err := binary.Read(reader, binary.LittleEndian, &numberEntries); err != nil {
+		return nil, ErrCorruptedSSTable
+	}
+	header.NumberEntries = uint(numberEntries)
+
+	return header, nil
 }
 
-func ReadBlock(blockSize uint32, file *os.File) ([]*SSTableEntry, bool, *SSTable, error) {
-	entries := make([]*SSTableEntry, blockSize)
-	for i := 0; i < int(blockSize); i++ {
-		if err := binary.Read(file, binary.LittleEndian, &entries[i].Key); err != nil {
-			return nil, true, nil, err
+func encodeHeader(buf *bytes.Buffer, header *SSTableHeader) {
+	binary.Write(buf, binary.LittleEndian, header.Level)
+	binary.Write(buf, binary.LittleEndian, header.Timestamp)
+	writeString(buf, header.Version)
+	binary.Write(buf, binary.LittleEndian, header.BlockSize)
+	binary.Write(buf, binary.LittleEndian, uint64(header.NumberEntries))
+}
+
+func decodeBlock(reader *bytes.Reader) ([]*SSTableEntry, error) {
+	var numberEntries uint32
+
+	if err := binary.Read(reader, binary.LittleEndian, &numberEntries); err != nil {
+		return nil, ErrCorruptedSSTable
+	}
+
+	entries := make([]*SSTableEntry, 0, numberEntries)
+
+	for i := 0; i < int(numberEntries); i++ {
+		key, err := readString(reader)
+		if err != nil {
+			return nil, err
 		}
-		var valueSize uint32
-		if err := binary.Read(file, binary.LittleEndian, &valueSize); err != nil {
-			return nil, true, nil, err
+
+		var sequence uint64
+		if err := binary.Read(reader, binary.LittleEndian, &sequence); err != nil {
+			return nil, ErrCorruptedSSTable
 		}
-		valueBytes := make([]byte, valueSize)
-		if _, err := file.Read(valueBytes); err != nil {
-			return nil, true, nil, err
+
+		value, err := readString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		kind, err := reader.ReadByte()
+		if err != nil || kind > entryExpiringValue {
+			return nil, ErrCorruptedSSTable
 		}
-		entries[i].Value = string(valueBytes)
 
-		if err := binary.Read(file, binary.LittleEndian, &entries[i].IsTombstone); err != nil {
-			return nil, true, nil, err
+		entry := NewSSTableEntry(key, sequence, value, kind == entryTombstone)
+		entry.IsMerge = kind == entryMerge
+
+		if kind == entryExpiringValue {
+			if err := binary.Read(reader, binary.LittleEndian, &entry.ExpiresAt); err != nil {
+				return nil, ErrCorruptedSSTable
+			}
 		}
+
+		entries = append(entries, entry)
 	}
-	return entries, false, nil, nil
+
+	return entries, nil
 }
 
-func (sstable *SSTable) WriteToFile(filename string) error {
+func encodeBlock(buf *bytes.Buffer, block *SSTableBlock) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(block.Entries)))
+
+	for _, entry := range block.Entries {
+		writeString(buf, entry.Key)
+		binary.Write(buf, binary.LittleEndian, entry.Sequence)
+		writeString(buf, entry.Value)
+		buf.WriteByte(entryKind(entry))
+
+		if entry.ExpiresAt != 0 {
+			binary.Write(buf, binary.LittleEndian, entry.ExpiresAt)
+		}
+	}
+}
+
+// entry kinds as stored in data blocks
+const (
+	entryValue byte = iota
+	entryTombstone
+	entryMerge
+	entryExpiringValue
+)
+
+func entryKind(entry *SSTableEntry) byte {
+	switch {
+	case entry.IsTombstone:
+		return entryTombstone
+	case entry.IsMerge:
+		return entryMerge
+	case entry.ExpiresAt != 0:
+		return entryExpiringValue
+	default:
+		return entryValue
+	}
+}
+
+func (sstable *SSTable) decodeIndex(reader *bytes.Reader) error {
+	var numberBlocks uint32
+
+	if err := binary.Read(reader, binary.LittleEndian, &numberBlocks); err != nil {
+		return ErrCorruptedSSTable
+	}
+
+	for i := 0; i < int(numberBlocks); i++ {
+		anchorKey, err := readString(reader)
+		if err != nil {
+			return err
+		}
+
+		block := &SSTableBlock{
+			Sequence: i + 1,
+			Anchor:   &SSTableEntry{Key: anchorKey},
+		}
+
+		if err := binary.Read(reader, binary.LittleEndian, &block.Anchor.Sequence); err != nil {
+			return ErrCorruptedSSTable
+		}
+
+		if err := binary.Read(reader, binary.LittleEndian, &block.Offset); err != nil {
+			return ErrCorruptedSSTable
+		}
+
+		if err := binary.Read(reader, binary.LittleEndian, &block.Size); err != nil {
+			return ErrCorruptedSSTable
+		}
+
+		sstable.Blocks = append(sstable.Blocks, block)
+	}
+
+	var numberTombstones uint32
+
+	if err := binary.Read(reader, binary.LittleEndian, &numberTombstones); err != nil {
+		return ErrCorruptedSSTable
+	}
+
+	for i := 0; i < int(numberTombstones); i++ {
+		start, err := readString(reader)
+		if err != nil {
+			return err
+		}
+
+		end, err := readString(reader)
+		if err != nil {
+			return err
+		}
+
+		tombstone := iterator.RangeTombstone{Start: start, End: end}
+
+		if err := binary.Read(reader, binary.LittleEndian, &tombstone.Sequence); err != nil {
+			return ErrCorruptedSSTable
+		}
 
-	file, err := os.Create("./" + SSTABLE_FOLDER + filename) // todo fix root path
+		sstable.RangeTombstones = append(sstable.RangeTombstones, tombstone)
+	}
 
+	largestKey, err := readString(reader)
 	if err != nil {
 		return err
 	}
+	sstable.LargestKey = largestKey
 
-	defer file.Close()
+	return nil
+}
 
-	// Write header
-	wrerr := binary.Write(file, binary.LittleEndian, sstable.Header)
+func (sstable *SSTable) encodeIndex(buf *bytes.Buffer) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(sstable.Blocks)))
 
-	if wrerr != nil {
-		return wrerr
+	for _, block := range sstable.Blocks {
+		writeString(buf, block.Anchor.Key)
+		binary.Write(buf, binary.LittleEndian, block.Anchor.Sequence)
+		binary.Write(buf, binary.LittleEndian, block.Offset)
+		binary.Write(buf, binary.LittleEndian, block.Size)
 	}
 
-	// Write blocks
-	for _, block := range sstable.Blocks {
+	binary.Write(buf, binary.LittleEndian, uint32(len(sstable.RangeTombstones)))
+
+	for _, tombstone := range sstable.RangeTombstones {
+		writeString(buf, tombstone.Start)
+		writeString(buf, tombstone.End)
+		binary.Write(buf, binary.LittleEndian, tombstone.Sequence)
+	}
 
-		wrerr := binary.Write(file, binary.LittleEndian, uint32(len(block.Entries)))
+	writeString(buf, sstable.LargestKey)
+}
 
-		if wrerr != nil {
-			return wrerr
+// decodeFilters restores the table and block filters exactly as they were
+// built, including their N, P and hash function.
+func (sstable *SSTable) decodeFilters(reader *bytes.Reader) error {
+	tableFilter, err := readFilter(reader)
+	if err != nil {
+		return err
+	}
+	sstable.Filter = tableFilter
+
+	var numberBlocks uint32
+	if err := binary.Read(reader, binary.LittleEndian, &numberBlocks); err != nil || int(numberBlocks) != len(sstable.Blocks) {
+		return ErrCorruptedSSTable
+	}
+
+	for _, block := range sstable.Blocks {
+		if block.Filter, err = readFilter(reader); err != nil {
+			return err
 		}
+	}
 
-		shouldReturn, returnValue := WriteBlock(block, file)
+	return nil
+}
+
+func readFilter(reader *bytes.Reader) (*core.BloomFilter, error) {
+	data, err := readString(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := core.UnmarshalBloomFilter([]byte(data))
+	if err != nil {
+		return nil, ErrCorruptedSSTable
+	}
+
+	return filter, nil
+}
+
+// encodeFilters encodes the filter block ahead of writing, since encoding a
+// filter can fail.
+func (sstable *SSTable) encodeFilters() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	tableFilter, err := sstable.Filter.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	writeString(buf, string(tableFilter))
+
+	binary.Write(buf, binary.LittleEndian, uint32(len(sstable.Blocks)))
 
-		if shouldReturn {
-			return returnValue
+	for _, block := range sstable.Blocks {
+		blockFilter, err := block.Filter.MarshalBinary()
+		if err != nil {
+			return nil, err
 		}
+		writeString(buf, string(blockFilter))
+	}
+
+	return buf.Bytes(), nil
+}
+
+func readString(reader *bytes.Reader) (string, error) {
+	var length uint32
+
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return "", ErrCorruptedSSTable
+	}
+
+	if int64(length) > int64(reader.Len()) {
+		return "", ErrCorruptedSSTable
 	}
 
-	// if err := binary.Write(file, binary.LittleEndian, sstable.Footer); err != nil {
-	// 	return err
-	// }
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", ErrCorruptedSSTable
+	}
+
+	return string(data), nil
+}
+
+func writeString(buf *bytes.Buffer, value string) {
+	binary.Write(buf, binary.LittleEndian, uint32(len(value)))
+	buf.WriteString(value)
+}
+
+// writeSection appends the section built by encode to buf, followed by its
+// checksum, and returns where the section starts and how long it is.
+func writeSection(buf *bytes.Buffer, encode func(section *bytes.Buffer)) (uint64, uint32) {
+	offset := buf.Len()
+
+	encode(buf)
+
+	contents := buf.Bytes()[offset:]
+	binary.Write(buf, binary.LittleEndian, crc32.Checksum(contents, crcTable))
+
+	return uint64(offset), uint32(len(contents))
+}
+
+// WriteToFile durably writes the SSTable into directory. The table is written
+// to a temporary file first and renamed into place, so a crash never leaves a
+// partially written table behind. Afterwards the block entries are dropped
+// from memory and read back from the file on demand.
+func (sstable *SSTable) WriteToFile(directory string) error {
+
+	buf := new(bytes.Buffer)
+	footer := &SSTableFooter{FormatVersion: FORMAT_VERSION}
+
+	_, footer.HeaderSize = writeSection(buf, func(section *bytes.Buffer) {
+		encodeHeader(section, sstable.Header)
+	})
+
+	for _, block := range sstable.Blocks {
+		block.Offset, block.Size = writeSection(buf, func(section *bytes.Buffer) {
+			encodeBlock(section, block)
+		})
+	}
+
+	filters, err := sstable.encodeFilters()
+
+	if err != nil {
+		return err
+	}
+
+	footer.FilterOffset, footer.FilterSize = writeSection(buf, func(section *bytes.Buffer) {
+		section.Write(filters)
+	})
+
+	footer.IndexOffset, footer.IndexSize = writeSection(buf, sstable.encodeIndex)
+
+	buf.Write(encodeFooter(footer))
+
+	filename := sstable.FilePath(directory)
+	tempFilename := filename + TEMP_EXTENSION
+
+	file, err := os.Create(tempFilename)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFilename, filename); err != nil {
+		return err
+	}
+
+	// AddSSTable logs the table in the MANIFEST next, which must not refer to
+	// a file a crash can still lose
+	if err := syncDirectory(directory); err != nil {
+		return err
+	}
+
+	readFile, err := os.Open(filename)
+
+	if err != nil {
+		return err
+	}
+
+	sstable.Footer = footer
+	sstable.file = readFile
+
+	for _, block := range sstable.Blocks {
+		block.Entries = nil
+	}
 
 	return nil
 }
 
-func WriteBlock(block *SSTableBlock, file *os.File) (bool, error) {
+// RemoveOrphanFiles deletes the SSTable files in directory that are not live,
+// along with leftover temporary files from an interrupted write.
+func RemoveOrphanFiles(directory string, live map[uint64]bool) error {
+	dirEntries, err := os.ReadDir(directory)
 
-	for _, entry := range block.Entries {
+	if err != nil {
+		return err
+	}
 
-		if err := binary.Write(file, binary.LittleEndian, entry.Key); err != nil {
-			return true, err
-		}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
 
-		valueBytes := []byte(entry.Value)
-		if err := binary.Write(file, binary.LittleEndian, uint32(len(valueBytes))); err != nil {
-			return true, err
+		if dirEntry.IsDir() {
+			continue
 		}
 
-		if _, err := file.Write(valueBytes); err != nil {
-			return true, err
+		isTemp := strings.HasSuffix(name, TEMP_EXTENSION)
+
+		if !isTemp {
+			if !strings.HasSuffix(name, SSTABLE_EXTENSION) {
+				continue
+			}
+
+			fileNumber, err := strconv.ParseUint(strings.TrimSuffix(name, SSTABLE_EXTENSION), 10, 64)
+
+			if err != nil || live[fileNumber] {
+				continue
+			}
 		}
 
-		if err := binary.Write(file, binary.LittleEndian, entry.IsTombstone); err != nil {
-			return true, err
+		if err := os.Remove(filepath.Join(directory, name)); err != nil {
+			return err
 		}
 	}
 
-	return false, nil
+	return nil
+}
+
+// syncDirectory makes the files created and renamed in directory durable.
+func syncDirectory(directory string) error {
+	dir, err := os.Open(directory)
+
+	if err != nil {
+		return err
+	}
+
+	if err := dir.Sync(); err != nil {
+		dir.Close()
+		return err
+	}
+
+	return dir.Close()
 }
@@ -0,0 +1,440 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWriteAndLoad(t *testing.T) {
+	tests := []struct {
+		name            string
+		blockCapacity   int
+		entries         []*SSTableEntry
+		rangeTombstones []iterator.RangeTombstone
+	}{
+		{
+			name:          "values and tombstones",
+			blockCapacity: 2048,
+			entries: []*SSTableEntry{
+				{Key: "a", Sequence: 3, Value: "value"},
+				{Key: "b", Sequence: 1, IsTombstone: true},
+				{Key: "c", Sequence: 2, Value: ""},
+				{Key: "d", Sequence: 4, Value: "expiring", ExpiresAt: math.MaxInt64},
+			},
+		},
+		{
+			name:          "range tombstones",
+			blockCapacity: 2048,
+			entries:       []*SSTableEntry{{Key: "a", Sequence: 2, Value: "value"}},
+			rangeTombstones: []iterator.RangeTombstone{
+				{Start: "a", End: "c", Sequence: 3},
+				{Start: "x", Sequence: 1},
+			},
+		},
+		{
+			name:          "many blocks",
+			blockCapacity: 3,
+			entries:       numberedEntries(20),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setBlockCapacity(t, test.blockCapacity)
+
+			directory := t.TempDir()
+			written := writeTable(t, directory, test.entries, test.rangeTombstones)
+
+			loaded, err := LoadFromFile(directory, written.FileNumber)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer loaded.Unref()
+
+			if got := tableEntries(t, loaded); !reflect.DeepEqual(got, test.entries) {
+				t.Errorf("entries: got %v, want %v", got, test.entries)
+			}
+
+			if !reflect.DeepEqual(loaded.RangeTombstones, test.rangeTombstones) {
+				t.Errorf("range tombstones: got %v, want %v", loaded.RangeTombstones, test.rangeTombstones)
+			}
+
+			if loaded.LargestKey != written.LargestKey {
+				t.Errorf("largest key: got %q, want %q", loaded.LargestKey, written.LargestKey)
+			}
+
+			if len(loaded.Blocks) != len(written.Blocks) {
+				t.Errorf("got %d blocks, want %d", len(loaded.Blocks), len(written.Blocks))
+			}
+
+			if loaded.Header.Level != written.Header.Level || loaded.Header.Timestamp != written.Header.Timestamp ||
+				loaded.Header.NumberEntries != written.Header.NumberEntries {
+				t.Errorf("header: got %+v, want %+v", loaded.Header, written.Header)
+			}
+
+			for _, entry := range test.entries {
+				if loaded.DoesNotExist(entry.Key) {
+					t.Errorf("table filter rules out %q", entry.Key)
+				}
+
+				result, err := loaded.ReadFromSSTable(entry.Key, entry.Sequence)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if result.Value != entry.Value || (result.Status == models.Deleted) != entry.IsTombstone {
+					t.Errorf("reading %q at %d: got %+v", entry.Key, entry.Sequence, result)
+				}
+			}
+
+			for blockID, block := range loaded.Blocks {
+				for _, entry := range written.Blocks[blockID].Entries {
+					if block.Filter.DoesNotExist([]byte(entry.Key)) {
+						t.Errorf("filter of block %d rules out %q", blockID, entry.Key)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCreateSSTableKeepsSnapshotVersions(t *testing.T) {
+	entries := []*SSTableEntry{
+		{Key: "a", Sequence: 7, Value: "a7"},
+		{Key: "a", Sequence: 5, Value: "a5"},
+		{Key: "a", Sequence: 3, Value: "a3"},
+		{Key: "a", Sequence: 1, Value: "a1"},
+		{Key: "b", Sequence: 4, IsTombstone: true},
+		{Key: "b", Sequence: 2, Value: "b2"},
+		{Key: "c", Sequence: 6, Value: "c6"},
+		{Key: "c", Sequence: 5, Value: "c5"},
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []uint64
+		want      []string
+	}{
+		{
+			name: "no snapshots",
+			want: []string{"a@7", "b@4", "c@6"},
+		},
+		{
+			name:      "snapshots at 2 and 5",
+			snapshots: []uint64{2, 5},
+			want:      []string{"a@7", "a@5", "a@1", "b@4", "b@2", "c@6", "c@5"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table := CreateSSTable(newEntryIterator(entries), nil, uint(len(entries)), 0, test.snapshots)
+
+			got := make([]string, 0)
+			for _, block := range table.Blocks {
+				for _, entry := range block.Entries {
+					got = append(got, fmt.Sprint(entry.Key, "@", entry.Sequence))
+				}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+
+			if table.Header.NumberEntries != uint(len(test.want)) {
+				t.Errorf("header counts %d entries, want %d", table.Header.NumberEntries, len(test.want))
+			}
+		})
+	}
+}
+
+// Compactions keep older versions of a key, which may then span blocks.
+func TestReadVersionsAcrossBlocks(t *testing.T) {
+	setBlockCapacity(t, 4)
+
+	directory := t.TempDir()
+
+	table := OpenSSTable(uint8(configs.GetStorageEngineConfig().SSTableConfig.FirstLevel), 12)
+	table.FileNumber = 1
+
+	// versions 12 down to 2 of "a", every third one from 10 a tombstone, then "b"
+	for sequence := uint64(12); sequence >= 2; sequence-- {
+		table.AddEntry(NewSSTableEntry("a", sequence, fmt.Sprint("a", sequence), sequence%3 == 1))
+	}
+	table.AddEntry(NewSSTableEntry("b", 1, "b", false))
+
+	if err := table.CompleteSSTableCreation().WriteToFile(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFromFile(directory, table.FileNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Unref()
+
+	if len(loaded.Blocks) != 3 {
+		t.Fatalf("got %d blocks, want 3", len(loaded.Blocks))
+	}
+
+	for sequence := uint64(1); sequence <= 13; sequence++ {
+		want := models.NewFoundResult(fmt.Sprint("a", sequence), sequence)
+
+		switch {
+		case sequence == 1:
+			want = models.NewNotFoundResult()
+		case sequence == 13:
+			want = models.NewFoundResult("a12", 12)
+		case sequence%3 == 1:
+			want = models.NewDeletedResult(sequence)
+		}
+
+		got, err := loaded.ReadFromSSTable("a", sequence)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("reading at %d: got %+v, want %+v", sequence, got, want)
+		}
+	}
+}
+
+func TestLoadRejectsDamagedFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		damage  func(data []byte) []byte
+		wantErr error
+	}{
+		{
+			name:    "flipped data byte",
+			damage:  func(data []byte) []byte { data[len(data)/2] ^= 0xff; return data },
+			wantErr: ErrCorruptedSSTable,
+		},
+		{
+			name:    "flipped footer byte",
+			damage:  func(data []byte) []byte { data[len(data)-footerSize] ^= 0xff; return data },
+			wantErr: ErrCorruptedSSTable,
+		},
+		{
+			name:    "truncated",
+			damage:  func(data []byte) []byte { return data[:len(data)-1] },
+			wantErr: ErrCorruptedSSTable,
+		},
+		{
+			name:    "shorter than a footer",
+			damage:  func(data []byte) []byte { return data[:footerSize-1] },
+			wantErr: ErrCorruptedSSTable,
+		},
+		{
+			name: "other format version",
+			damage: func(data []byte) []byte {
+				footer, _ := decodeFooter(data[len(data)-footerSize:])
+				footer.FormatVersion++
+				return append(data[:len(data)-footerSize], encodeFooter(footer)...)
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setBlockCapacity(t, 4)
+
+			directory := t.TempDir()
+			table := writeTable(t, directory, numberedEntries(16), nil)
+			filename := table.FilePath(directory)
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filename, test.damage(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadFromFile(directory, table.FileNumber)
+
+			// a damaged data block is only noticed when it is read
+			if err == nil {
+				defer loaded.Unref()
+
+				for blockID := range loaded.Blocks {
+					if _, err = loaded.BlockEntries(blockID); err != nil {
+						break
+					}
+				}
+			}
+
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadFromSSTableReportsDamagedBlock(t *testing.T) {
+	setBlockCapacity(t, 4)
+
+	directory := t.TempDir()
+	table := writeTable(t, directory, numberedEntries(16), nil)
+	filename := table.FilePath(directory)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// damage the second block, which holds key004 to key007
+	data[table.Blocks[1].Offset] ^= 0xff
+
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadFromFile(directory, table.FileNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Unref()
+
+	if _, err := loaded.ReadFromSSTable("key005", iterator.MaxSequence); !errors.Is(err, ErrCorruptedSSTable) {
+		t.Errorf("reading the damaged block: got %v, want %v", err, ErrCorruptedSSTable)
+	}
+
+	if result, err := loaded.ReadFromSSTable("key010", iterator.MaxSequence); err != nil || result.Value != "value10" {
+		t.Errorf("reading another block: got %+v, %v", result, err)
+	}
+}
+
+func TestRemoveOrphanFiles(t *testing.T) {
+	directory := t.TempDir()
+
+	for fileNumber := uint64(1); fileNumber <= 3; fileNumber++ {
+		table := CreateSSTable(newEntryIterator([]*SSTableEntry{{Key: fmt.Sprint(fileNumber)}}), nil, 1, 0, nil)
+		table.FileNumber = fileNumber
+
+		if err := table.WriteToFile(directory); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"000004" + SSTABLE_EXTENSION + TEMP_EXTENSION, "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RemoveOrphanFiles(directory, map[uint64]bool{1: true, 3: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0)
+	for _, dirEntry := range dirEntries {
+		names = append(names, dirEntry.Name())
+	}
+
+	if want := []string{"000001.sst", "000003.sst", "notes.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("files left %v, want %v", names, want)
+	}
+}
+
+// writeTable writes the entries, sorted in internal key order, and the range
+// tombstones to a table in directory.
+func writeTable(t *testing.T, directory string, entries []*SSTableEntry, rangeTombstones []iterator.RangeTombstone) *SSTable {
+	t.Helper()
+
+	table := CreateSSTable(newEntryIterator(entries), rangeTombstones, uint(len(entries)), uint8(configs.GetStorageEngineConfig().SSTableConfig.FirstLevel), nil)
+	table.FileNumber = 1
+
+	if err := table.WriteToFile(directory); err != nil {
+		t.Fatal(err)
+	}
+
+	return table
+}
+
+// entryIterator walks entries already in internal key order.
+type entryIterator struct {
+	entries  []*SSTableEntry
+	position int
+}
+
+func newEntryIterator(entries []*SSTableEntry) *entryIterator {
+	return &entryIterator{entries: entries}
+}
+
+func (it *entryIterator) SeekToFirst() {
+	it.position = 0
+}
+
+func (it *entryIterator) Seek(key string) {
+	it.position = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].Key >= key })
+}
+
+func (it *entryIterator) Next() {
+	it.position++
+}
+
+func (it *entryIterator) Valid() bool {
+	return it.position < len(it.entries)
+}
+
+func (it *entryIterator) Entry() *iterator.Entry {
+	entry := it.entries[it.position]
+
+	return &iterator.Entry{Key: entry.Key, Sequence: entry.Sequence, Value: entry.Value, IsTombstone: entry.IsTombstone, ExpiresAt: entry.ExpiresAt}
+}
+
+func (it *entryIterator) Close() error {
+	return nil
+}
+
+// tableEntries reads the entries of every block of table.
+func tableEntries(t *testing.T, table *SSTable) []*SSTableEntry {
+	entries := make([]*SSTableEntry, 0)
+
+	for blockID := range table.Blocks {
+		block, err := table.BlockEntries(blockID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries = append(entries, block...)
+	}
+
+	return entries
+}
+
+func numberedEntries(count int) []*SSTableEntry {
+	entries := make([]*SSTableEntry, count)
+
+	for i := range entries {
+		entries[i] = &SSTableEntry{Key: fmt.Sprintf("key%03d", i), Value: fmt.Sprint("value", i)}
+	}
+
+	return entries
+}
+
+func setBlockCapacity(t *testing.T, capacity int) {
+	config := configs.GetStorageEngineConfig()
+	previous := config.SSTableConfig.BlockCapacity
+	config.SSTableConfig.BlockCapacity = capacity
+
+	t.Cleanup(func() { config.SSTableConfig.BlockCapacity = previous })
+}
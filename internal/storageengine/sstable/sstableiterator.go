@@ -0,0 +1,100 @@
+package sstable
+
+import (
+	"pkvstore/internal/storageengine/iterator"
+	"sort"
+)
+
+// SSTableIterator walks the entries of an SSTable in key order, reading one
+// block at a time. It holds a reference on the table until Close.
+type SSTableIterator struct {
+	sstable *SSTable
+	blockID int
+	entries []*SSTableEntry
+	entryID int
+	err     error
+}
+
+// NewIterator returns an unpositioned iterator over the table.
+func (sstable *SSTable) NewIterator() *SSTableIterator {
+	sstable.Ref()
+
+	return &SSTableIterator{
+		sstable: sstable,
+		blockID: len(sstable.Blocks),
+	}
+}
+
+func (it *SSTableIterator) SeekToFirst() {
+	it.loadBlock(0)
+	it.skipExhaustedBlocks()
+}
+
+func (it *SSTableIterator) Seek(key string) {
+	// versions of key may start in the last block anchored before key
+	blockID := sort.Search(len(it.sstable.Blocks), func(i int) bool {
+		return it.sstable.Blocks[i].Anchor.Key >= key
+	}) - 1
+
+	if blockID < 0 {
+		blockID = 0
+	}
+
+	it.loadBlock(blockID)
+
+	it.entryID = sort.Search(len(it.entries), func(i int) bool {
+		return it.entries[i].Key >= key
+	})
+
+	it.skipExhaustedBlocks()
+}
+
+func (it *SSTableIterator) Next() {
+	it.entryID++
+	it.skipExhaustedBlocks()
+}
+
+func (it *SSTableIterator) Valid() bool {
+	return it.err == nil && it.entryID < len(it.entries)
+}
+
+func (it *SSTableIterator) Entry() *iterator.Entry {
+	entry := it.entries[it.entryID]
+
+	return &iterator.Entry{
+		Key:         entry.Key,
+		Sequence:    entry.Sequence,
+		Value:       entry.Value,
+		IsTombstone: entry.IsTombstone,
+		IsMerge:     entry.IsMerge,
+		ExpiresAt:   entry.ExpiresAt,
+	}
+}
+
+// Close releases the table and returns the first block read error, if any.
+func (it *SSTableIterator) Close() error {
+	if it.sstable != nil {
+		it.sstable.Unref()
+		it.sstable = nil
+	}
+
+	return it.err
+}
+
+func (it *SSTableIterator) loadBlock(blockID int) {
+	it.blockID = blockID
+	it.entries = nil
+	it.entryID = 0
+
+	if it.err != nil || blockID >= len(it.sstable.Blocks) {
+		return
+	}
+
+	it.entries, it.err = it.sstable.BlockEntries(blockID)
+}
+
+func (it *SSTableIterator) skipExhaustedBlocks() {
+	for it.err == nil && it.entryID >= len(it.entries) && it.blockID < len(it.sstable.Blocks) {
+		it.loadBlock(it.blockID + 1)
+	}
+}
@@ -1,55 +1,255 @@
 package store
 
 import (
+	"errors"
+	"log"
+	"os"
 	"pkvstore/internal/models"
 	"pkvstore/internal/storageengine/backgroundprocess"
 	"pkvstore/internal/storageengine/channels"
+	"pkvstore/internal/storageengine/compactionfilter"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
 	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/memtable"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"pkvstore/internal/storageengine/wal"
+	"sync/atomic"
+	"time"
 )
 
+var ErrInvalidTTL = errors.New("ttl must be positive")
+
 type Store struct {
 	lsmTree    *lsmtree.LSMTree
 	compaction *backgroundprocess.Compaction
 	sharedChan *channels.SharedChannel
+
+	locks             *lockManager
+	lastTransactionID atomic.Uint64
 }
 
 func NewStore() *Store {
-	lsm := lsmtree.NewLSMTree()
+	memTable := memtable.NewMemTable()
+	writeAheadLog := openWriteAheadLog(memTable)
+
+	lsm := lsmtree.NewLSMTree(memTable, writeAheadLog)
 	compaction := backgroundprocess.NewCompaction(lsm)
 
 	return &Store{
 		lsmTree:    lsm,
 		compaction: compaction,
 		sharedChan: channels.GetSharedChannel(),
+		locks:      newLockManager(),
 	}
 }
 
-func (store *Store) Get(key string) *models.Result {
+// openWriteAheadLog replays the existing WAL segments into the memtable and
+// then starts a new segment for the writes that follow.
+func openWriteAheadLog(memTable *memtable.MemTable) *wal.WriteAheadLog {
+	config := configs.GetStorageEngineConfig()
 
-	result := store.lsmTree.Get(key)
+	if err := os.MkdirAll(config.WALConfig.Directory, 0755); err != nil {
+		log.Fatal("Creating WAL directory:", err)
+	}
+
+	if config.WALConfig.ArchiveDirectory != "" {
+		if err := os.MkdirAll(config.WALConfig.ArchiveDirectory, 0755); err != nil {
+			log.Fatal("Creating WAL archive directory:", err)
+		}
+	}
+
+	lastSequence, lastSegment, err := wal.Replay(config.WALConfig.Directory, config.WALConfig.RecoveryMode, func(entry *wal.LogEntry) {
+		switch entry.Operation {
+		case wal.InsertOperation, wal.UpdateOperation:
+			memTable.PutWithExpiry(entry.Key, entry.Value, entry.ExpiresAt, entry.Sequence)
+		case wal.DeleteOperation:
+			memTable.Delete(entry.Key, entry.Sequence)
+		case wal.MergeOperation:
+			memTable.Merge(entry.Key, entry.Value, entry.Sequence)
+		case wal.DeleteRangeOperation:
+			memTable.DeleteRange(entry.Key, entry.Value, entry.Sequence)
+		}
+	})
+
+	if err != nil {
+		log.Fatal("Replaying WAL:", err)
+	}
+
+	writeAheadLog, err := wal.NewWriteAheadLog(config.WALConfig.Directory, lastSegment+1, lastSequence)
+
+	if err != nil {
+		log.Fatal("Opening WAL:", err)
+	}
+
+	return writeAheadLog
+}
+
+// Get reads key as of snapshot, or the newest write when snapshot is nil. It
+// fails when the merge operands of key cannot be combined.
+func (store *Store) Get(key string, snapshot *lsmtree.Snapshot) (*models.Result, error) {
+
+	result, err := store.lsmTree.Get(key, snapshot)
 
 	store.notifyReadOperation()
 
-	return result
+	return result, err
 }
 
-func (store *Store) Put(key, value string) {
+// NewIterator returns an iterator over the keys in [start, end) as of
+// snapshot, or the newest write when snapshot is nil; an empty end scans to
+// the last key. The caller must Close it.
+func (store *Store) NewIterator(start string, end string, snapshot *lsmtree.Snapshot) *iterator.Iterator {
+	return store.lsmTree.NewIterator(start, end, snapshot)
+}
 
-	store.lsmTree.Put(key, value)
+// NewPrefixIterator returns an iterator over the keys starting with prefix.
+func (store *Store) NewPrefixIterator(prefix string, snapshot *lsmtree.Snapshot) *iterator.Iterator {
+	return store.lsmTree.NewIterator(prefix, iterator.PrefixEnd(prefix), snapshot)
+}
+
+// GetSnapshot returns a consistent view of the store as of now for Get and
+// iterators. It must be released with ReleaseSnapshot.
+func (store *Store) GetSnapshot() *lsmtree.Snapshot {
+	return store.lsmTree.GetSnapshot()
+}
+
+func (store *Store) ReleaseSnapshot(snapshot *lsmtree.Snapshot) {
+	store.lsmTree.ReleaseSnapshot(snapshot)
+}
+
+func (store *Store) Put(key, value string) error {
+
+	if err := store.lsmTree.Put(key, value); err != nil {
+		return err
+	}
+
+	store.notifyWriteOperation()
+
+	return nil
+}
+
+// PutWithTTL writes value for key, to read as deleted once ttl has passed
+// and to be dropped by compaction after that. ttl must be positive.
+func (store *Store) PutWithTTL(key, value string, ttl time.Duration) error {
+
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	if err := store.lsmTree.PutWithTTL(key, value, ttl); err != nil {
+		return err
+	}
 
 	store.notifyWriteOperation()
+
+	return nil
 }
 
-func (store *Store) Delete(key string) {
+func (store *Store) Delete(key string) error {
 
-	store.lsmTree.Delete(key)
+	if err := store.lsmTree.Delete(key); err != nil {
+		return err
+	}
 
 	store.notifyWriteOperation()
+
+	return nil
+}
+
+// DeleteRange deletes every key in [start, end) by writing a single range
+// tombstone. An empty end deletes every key from start on.
+func (store *Store) DeleteRange(start, end string) error {
+
+	if err := store.lsmTree.DeleteRange(start, end); err != nil {
+		return err
+	}
+
+	store.notifyWriteOperation()
+
+	return nil
+}
+
+// Merge records operand for key without reading it. The configured merge
+// operator combines it with the value of key when key is read.
+func (store *Store) Merge(key, operand string) error {
+
+	if err := store.lsmTree.Merge(key, operand); err != nil {
+		return err
+	}
+
+	store.notifyWriteOperation()
+
+	return nil
+}
+
+// CompareAndSwap sets key to newValue if it holds expectedValue, atomically
+// with respect to other writers, and reports whether it did.
+func (store *Store) CompareAndSwap(key, expectedValue, newValue string) (bool, error) {
+
+	return store.writeIf(store.lsmTree.CompareAndSwap(key, expectedValue, newValue))
+}
+
+// PutIfAbsent sets key to value if it does not exist and reports whether it
+// did.
+func (store *Store) PutIfAbsent(key, value string) (bool, error) {
+
+	return store.writeIf(store.lsmTree.PutIfAbsent(key, value))
+}
+
+// DeleteIfEquals deletes key if it holds expectedValue and reports whether it
+// did.
+func (store *Store) DeleteIfEquals(key, expectedValue string) (bool, error) {
+
+	return store.writeIf(store.lsmTree.DeleteIfEquals(key, expectedValue))
+}
+
+func (store *Store) writeIf(written bool, err error) (bool, error) {
+
+	if written {
+		store.notifyWriteOperation()
+	}
+
+	return written, err
+}
+
+// SetCompactionFilter has later compactions pass their values through filter, or none if nil.
+func (store *Store) SetCompactionFilter(filter compactionfilter.CompactionFilter) {
+
+	store.compaction.SetCompactionFilter(filter)
+}
+
+// SetMergeOperator has Merge, reads and later compactions combine merge operands with operator. Without one, Merge fails.
+func (store *Store) SetMergeOperator(operator mergeoperator.MergeOperator) {
+
+	store.lsmTree.SetMergeOperator(operator)
+}
+
+// Stats reports the shape of the LSM tree and how writes have been stalled.
+func (store *Store) Stats() *lsmtree.Stats {
+	return store.lsmTree.Stats()
+}
+
+// Write applies every operation of batch atomically.
+func (store *Store) Write(batch *lsmtree.WriteBatch) error {
+
+	if err := store.lsmTree.Write(batch); err != nil {
+		return err
+	}
+
+	store.notifyWriteOperation()
+
+	return nil
 }
 
 func (store *Store) notifyWriteOperation() {
 
-	store.sharedChan.SwitchMemtableEvent <- 1
+	// a pending event already makes the memtable check its size, so writers
+	// never block on a full channel
+	select {
+	case store.sharedChan.SwitchMemtableEvent <- 1:
+	default:
+	}
 }
 
 func (store *Store) notifyReadOperation() {
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		// "" leaves the key missing, "deleted" deletes it, anything else is its value
+		initial     string
+		write       func(store *Store) (bool, error)
+		wantWritten bool
+		want        string
+	}{
+		{
+			name:        "compare and swap on the expected value",
+			initial:     "old",
+			write:       func(store *Store) (bool, error) { return store.CompareAndSwap("k", "old", "new") },
+			wantWritten: true,
+			want:        "new",
+		},
+		{
+			name:    "compare and swap on another value",
+			initial: "other",
+			write:   func(store *Store) (bool, error) { return store.CompareAndSwap("k", "old", "new") },
+			want:    "other",
+		},
+		{
+			name:  "compare and swap on a missing key",
+			write: func(store *Store) (bool, error) { return store.CompareAndSwap("k", "", "new") },
+		},
+		{
+			name:    "compare and swap on a deleted key",
+			initial: "deleted",
+			write:   func(store *Store) (bool, error) { return store.CompareAndSwap("k", "", "new") },
+		},
+		{
+			name:        "put if absent on a missing key",
+			write:       func(store *Store) (bool, error) { return store.PutIfAbsent("k", "new") },
+			wantWritten: true,
+			want:        "new",
+		},
+		{
+			name:        "put if absent on a deleted key",
+			initial:     "deleted",
+			write:       func(store *Store) (bool, error) { return store.PutIfAbsent("k", "new") },
+			wantWritten: true,
+			want:        "new",
+		},
+		{
+			name:    "put if absent on an existing key",
+			initial: "old",
+			write:   func(store *Store) (bool, error) { return store.PutIfAbsent("k", "new") },
+			want:    "old",
+		},
+		{
+			name:        "delete if equals on the expected value",
+			initial:     "old",
+			write:       func(store *Store) (bool, error) { return store.DeleteIfEquals("k", "old") },
+			wantWritten: true,
+		},
+		{
+			name:    "delete if equals on another value",
+			initial: "other",
+			write:   func(store *Store) (bool, error) { return store.DeleteIfEquals("k", "old") },
+			want:    "other",
+		},
+		{
+			name:  "delete if equals on a missing key",
+			write: func(store *Store) (bool, error) { return store.DeleteIfEquals("k", "") },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+
+			switch test.initial {
+			case "":
+			case "deleted":
+				mustNotFail(t, store.Put("k", "old"))
+				mustNotFail(t, store.Delete("k"))
+			default:
+				mustNotFail(t, store.Put("k", test.initial))
+			}
+
+			written, err := test.write(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if written != test.wantWritten {
+				t.Errorf("written %v, want %v", written, test.wantWritten)
+			}
+
+			if got := storeValue(t, store, "k"); got != test.want {
+				t.Errorf("k is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// Concurrent writers land in the same commit groups, where each condition
+// must see the writes of the requests before it.
+func TestConcurrentConditionalWrites(t *testing.T) {
+	const writers = 16
+
+	store := newTestStore(t)
+	mustNotFail(t, store.Put("counter", "0"))
+
+	var wg sync.WaitGroup
+	var absentWinners atomic.Int32
+
+	for writer := 0; writer < writers; writer++ {
+		wg.Add(1)
+
+		go func(writer int) {
+			defer wg.Done()
+
+			if written, err := store.PutIfAbsent("claimed", strconv.Itoa(writer)); err != nil {
+				t.Error(err)
+			} else if written {
+				absentWinners.Add(1)
+			}
+
+			// retry until this writer's increment lands
+			for {
+				current := storeValue(t, store, "counter")
+				value, _ := strconv.Atoi(current)
+
+				written, err := store.CompareAndSwap("counter", current, strconv.Itoa(value+1))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				if written {
+					return
+				}
+			}
+		}(writer)
+	}
+
+	wg.Wait()
+
+	if absentWinners.Load() != 1 {
+		t.Errorf("%d writers claimed the key, want 1", absentWinners.Load())
+	}
+
+	if got := storeValue(t, store, "counter"); got != strconv.Itoa(writers) {
+		t.Errorf("counter is %s, want %d", got, writers)
+	}
+}
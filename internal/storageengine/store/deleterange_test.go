@@ -0,0 +1,53 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeleteRange(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		mustNotFail(t, store.Put(key, key))
+	}
+
+	snapshot := store.GetSnapshot()
+	defer store.ReleaseSnapshot(snapshot)
+
+	mustNotFail(t, store.DeleteRange("b", "d"))
+	mustNotFail(t, store.Put("c", "again"))
+
+	got := make([]string, 0)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		got = append(got, storeValue(t, store, key))
+	}
+
+	if want := []string{"a", "", "again", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	it := store.NewIterator("", "", nil)
+
+	keys := make([]string, 0)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	mustNotFail(t, it.Close())
+
+	if want := []string{"a", "c", "d", "e"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("iterating: got %q, want %q", keys, want)
+	}
+
+	if got := storeGet(t, store, "b", snapshot).Value; got != "b" {
+		t.Errorf("at the snapshot: got %q, want b", got)
+	}
+
+	mustNotFail(t, store.DeleteRange("d", ""))
+
+	for _, key := range []string{"d", "e"} {
+		if got := storeValue(t, store, key); got != "" {
+			t.Errorf("after the unbounded delete, %q holds %q", key, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrLockTimeout = errors.New("timed out waiting for a key lock")
+	ErrDeadlock    = errors.New("deadlock detected: the transaction was aborted")
+)
+
+// keyLock is an exclusive lock on one key. released is closed when the
+// holder lets go, waking every transaction waiting for the key.
+type keyLock struct {
+	holder   uint64
+	released chan struct{}
+	waiters  map[uint64]bool
+}
+
+// LockInfo describes a held key lock.
+type LockInfo struct {
+	Key           string
+	TransactionID uint64
+	Waiters       []uint64
+}
+
+// lockManager hands out the key locks of pessimistic transactions. A
+// transaction waits for at most one key at a time, so the wait-for graph is
+// a map from each waiting transaction to the holder it waits for.
+type lockManager struct {
+	mutex    sync.Mutex
+	locks    map[string]*keyLock
+	waitsFor map[uint64]uint64
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:    make(map[string]*keyLock),
+		waitsFor: make(map[uint64]uint64),
+	}
+}
+
+// lock takes the lock on key for the transaction, waiting up to timeout for
+// its holder to release it. Taking a lock already held is a no-op. When
+// waiting would close a cycle in the wait-for graph, the requesting
+// transaction is the victim and ErrDeadlock is returned.
+func (manager *lockManager) lock(transactionID uint64, key string, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		manager.mutex.Lock()
+
+		held, exists := manager.locks[key]
+
+		if !exists {
+			manager.locks[key] = &keyLock{
+				holder:   transactionID,
+				released: make(chan struct{}),
+				waiters:  make(map[uint64]bool),
+			}
+			manager.mutex.Unlock()
+			return nil
+		}
+
+		if held.holder == transactionID {
+			manager.mutex.Unlock()
+			return nil
+		}
+
+		if manager.waitsOn(held.holder, transactionID) {
+			manager.mutex.Unlock()
+			return ErrDeadlock
+		}
+
+		manager.waitsFor[transactionID] = held.holder
+		held.waiters[transactionID] = true
+		manager.mutex.Unlock()
+
+		select {
+		case <-held.released:
+			manager.stopWaiting(transactionID, held)
+		case <-timer.C:
+			manager.stopWaiting(transactionID, held)
+			return ErrLockTimeout
+		}
+	}
+}
+
+// waitsOn reports whether from waits, directly or through other
+// transactions, for to.
+func (manager *lockManager) waitsOn(from uint64, to uint64) bool {
+	for current, exists := from, true; exists; current, exists = manager.waitsFor[current] {
+		if current == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (manager *lockManager) stopWaiting(transactionID uint64, held *keyLock) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	delete(manager.waitsFor, transactionID)
+	delete(held.waiters, transactionID)
+}
+
+// unlock releases the locks the transaction holds on keys.
+func (manager *lockManager) unlock(transactionID uint64, keys []string) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	for _, key := range keys {
+		held, exists := manager.locks[key]
+
+		if !exists || held.holder != transactionID {
+			continue
+		}
+
+		delete(manager.locks, key)
+		close(held.released)
+	}
+}
+
+// status lists the held locks sorted by key.
+func (manager *lockManager) status() []LockInfo {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+
+	locks := make([]LockInfo, 0, len(manager.locks))
+
+	for key, held := range manager.locks {
+		waiters := make([]uint64, 0, len(held.waiters))
+		for waiter := range held.waiters {
+			waiters = append(waiters, waiter)
+		}
+		sort.Slice(waiters, func(i, j int) bool { return waiters[i] < waiters[j] })
+
+		locks = append(locks, LockInfo{Key: key, TransactionID: held.holder, Waiters: waiters})
+	}
+
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Key < locks[j].Key })
+
+	return locks
+}
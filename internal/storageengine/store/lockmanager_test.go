@@ -0,0 +1,168 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testLockTimeout = 5 * time.Second
+
+type heldLock struct {
+	transactionID uint64
+	key           string
+}
+
+func TestLockManagerLock(t *testing.T) {
+	tests := []struct {
+		name string
+		// locks taken in order before the checked one
+		held          []heldLock
+		transactionID uint64
+		key           string
+		wantErr       error
+	}{
+		{
+			name:          "free key",
+			transactionID: 1,
+			key:           "a",
+		},
+		{
+			name:          "key already held by the transaction",
+			held:          []heldLock{{1, "a"}},
+			transactionID: 1,
+			key:           "a",
+		},
+		{
+			name:          "other key",
+			held:          []heldLock{{1, "a"}},
+			transactionID: 2,
+			key:           "b",
+		},
+		{
+			name:          "key held by another transaction",
+			held:          []heldLock{{1, "a"}},
+			transactionID: 2,
+			key:           "a",
+			wantErr:       ErrLockTimeout,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := newLockManager()
+
+			for _, held := range test.held {
+				if err := manager.lock(held.transactionID, held.key, testLockTimeout); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := manager.lock(test.transactionID, test.key, 10*time.Millisecond); err != test.wantErr {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+
+			// a timed out waiter leaves nothing behind
+			for _, info := range manager.status() {
+				if len(info.Waiters) > 0 {
+					t.Errorf("%q still has waiters %v", info.Key, info.Waiters)
+				}
+			}
+		})
+	}
+}
+
+func TestLockManagerUnlockWakesWaiter(t *testing.T) {
+	manager := newLockManager()
+
+	if err := manager.lock(1, "a", testLockTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+
+	go func() {
+		acquired <- manager.lock(2, "a", testLockTimeout)
+	}()
+
+	waitForWaiters(t, manager, "a", []uint64{2})
+
+	// unlocking keys the transaction does not hold changes nothing
+	manager.unlock(2, []string{"a", "b"})
+	manager.unlock(1, []string{"a"})
+
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	want := []LockInfo{{Key: "a", TransactionID: 2, Waiters: []uint64{}}}
+
+	if got := manager.status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("status: got %+v, want %+v", got, want)
+	}
+}
+
+func TestLockManagerDetectsDeadlock(t *testing.T) {
+	manager := newLockManager()
+
+	for transactionID, key := range map[uint64]string{1: "a", 2: "b", 3: "c"} {
+		if err := manager.lock(transactionID, key, testLockTimeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 1 waits for 2, which waits for 3
+	waiting := make(chan error, 2)
+
+	go func() { waiting <- manager.lock(1, "b", testLockTimeout) }()
+	waitForWaiters(t, manager, "b", []uint64{1})
+
+	go func() { waiting <- manager.lock(2, "c", testLockTimeout) }()
+	waitForWaiters(t, manager, "c", []uint64{2})
+
+	if err := manager.lock(3, "a", testLockTimeout); err != ErrDeadlock {
+		t.Fatalf("closing the cycle: got %v, want %v", err, ErrDeadlock)
+	}
+
+	want := []LockInfo{
+		{Key: "a", TransactionID: 1, Waiters: []uint64{}},
+		{Key: "b", TransactionID: 2, Waiters: []uint64{1}},
+		{Key: "c", TransactionID: 3, Waiters: []uint64{2}},
+	}
+
+	if got := manager.status(); !reflect.DeepEqual(got, want) {
+		t.Errorf("status: got %+v, want %+v", got, want)
+	}
+
+	// the victim ending lets the others through
+	manager.unlock(3, []string{"c"})
+
+	if err := <-waiting; err != nil {
+		t.Fatal(err)
+	}
+
+	manager.unlock(2, []string{"b", "c"})
+
+	if err := <-waiting; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitForWaiters waits until the lock on key has the given waiters.
+func waitForWaiters(t *testing.T, manager *lockManager, key string, waiters []uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(testLockTimeout)
+
+	for time.Now().Before(deadline) {
+		for _, info := range manager.status() {
+			if info.Key == key && reflect.DeepEqual(info.Waiters, waiters) {
+				return
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("%q never had waiters %v", key, waiters)
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"errors"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/mergeoperator"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.Merge("counter", "1"); !errors.Is(err, mergeoperator.ErrNoMergeOperator) {
+		t.Fatalf("merging without a merge operator: got %v, want %v", err, mergeoperator.ErrNoMergeOperator)
+	}
+
+	store.SetMergeOperator(mergeoperator.Int64Add{})
+
+	mustNotFail(t, store.Merge("counter", "2"))
+	mustNotFail(t, store.Merge("counter", "3"))
+
+	if got := storeValue(t, store, "counter"); got != "5" {
+		t.Errorf("merging without a value: got %q, want 5", got)
+	}
+
+	snapshot := store.GetSnapshot()
+	defer store.ReleaseSnapshot(snapshot)
+
+	mustNotFail(t, store.Put("counter", "10"))
+	mustNotFail(t, store.Merge("counter", "-4"))
+
+	if got := storeValue(t, store, "counter"); got != "6" {
+		t.Errorf("merging over a value: got %q, want 6", got)
+	}
+
+	if got := storeGet(t, store, "counter", snapshot).Value; got != "5" {
+		t.Errorf("at the snapshot: got %q, want 5", got)
+	}
+
+	mustNotFail(t, store.Delete("counter"))
+	mustNotFail(t, store.Merge("counter", "1"))
+
+	if got := storeGet(t, store, "counter", nil); got.Status != models.Found || got.Value != "1" {
+		t.Errorf("merging over a tombstone: got %+v, want 1", got)
+	}
+
+	// conditional writes and iterators see the merged value too
+	mustNotFail(t, store.Merge("counter", "2"))
+
+	if swapped, err := store.CompareAndSwap("counter", "3", "7"); err != nil || !swapped {
+		t.Errorf("swapping the merged value: got %v, %v", swapped, err)
+	}
+
+	mustNotFail(t, store.Merge("counter", "1"))
+
+	it := store.NewIterator("counter", "", nil)
+	if !it.Valid() || it.Key() != "counter" || it.Value() != "8" {
+		t.Errorf("iterating: want counter=8")
+	}
+	mustNotFail(t, it.Close())
+
+	mustNotFail(t, store.Put("text", "not a number"))
+	mustNotFail(t, store.Merge("text", "1"))
+
+	if _, err := store.Get("text", nil); err == nil {
+		t.Error("operands that fail to merge: got no error")
+	}
+}
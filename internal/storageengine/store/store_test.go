@@ -0,0 +1,57 @@
+package store
+
+import (
+	"os"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"testing"
+)
+
+// The stores opened by the tests are never closed and keep reading the shared
+// config from their background goroutines, so it is set once for all of them.
+// The memtable is large enough that no test flushes.
+func TestMain(m *testing.M) {
+	configs.GetStorageEngineConfig().MemTableConfig.MaxSize = 1 << 30
+
+	os.Exit(m.Run())
+}
+
+// newTestStore opens a store in a temporary directory.
+func newTestStore(t *testing.T) *Store {
+	config := configs.GetStorageEngineConfig()
+
+	directory := t.TempDir() + "/"
+	config.DataDirectory = directory
+	config.SSTableConfig.Directory = directory + "sstable/"
+	config.WALConfig.Directory = directory + "wal/"
+
+	return NewStore()
+}
+
+// storeGet reads key as of snapshot and fails the test if the read fails.
+func storeGet(t *testing.T, store *Store, key string, snapshot *lsmtree.Snapshot) *models.Result {
+	t.Helper()
+
+	result, err := store.Get(key, snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+// storeValue returns the value of key, or "" when it is missing or deleted.
+func storeValue(t *testing.T, store *Store, key string) string {
+	t.Helper()
+
+	return storeGet(t, store, key, nil).Value
+}
+
+func mustNotFail(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+}
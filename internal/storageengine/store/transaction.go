@@ -0,0 +1,235 @@
+package store
+
+import (
+	"errors"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/lsmtree"
+	"sync"
+	"time"
+)
+
+var ErrTransactionClosed = errors.New("transaction is already committed or rolled back")
+
+// Txn is a transaction. It buffers its writes and applies them atomically on
+// Commit.
+//
+// An optimistic transaction reads from a snapshot taken when it began and
+// fails on Commit if a key it read or wrote was changed by someone else in
+// the meantime. A pessimistic transaction instead locks every key it writes
+// or reads with GetForUpdate until it ends, so its Commit cannot conflict
+// with other transactions. Writes made outside transactions take no locks.
+type Txn struct {
+	id       uint64
+	store    *Store
+	snapshot *lsmtree.Snapshot // nil for pessimistic transactions, which read the newest writes
+	batch    *lsmtree.WriteBatch
+	// buffered writes, so the transaction reads its own writes
+	writes map[string]*models.Result
+	// keys read or written, checked for conflicts on Commit
+	keys   map[string]bool
+	closed bool
+	mutex  sync.Mutex
+
+	pessimistic bool
+	lockTimeout time.Duration
+	locked      []string
+}
+
+// BeginTransaction starts an optimistic transaction. It must end with Commit
+// or Rollback.
+func (store *Store) BeginTransaction() *Txn {
+	return &Txn{
+		id:       store.lastTransactionID.Add(1),
+		store:    store,
+		snapshot: store.lsmTree.GetSnapshot(),
+		batch:    lsmtree.NewWriteBatch(),
+		writes:   make(map[string]*models.Result),
+		keys:     make(map[string]bool),
+	}
+}
+
+// BeginPessimisticTransaction starts a pessimistic transaction that waits up
+// to lockTimeout for each key lock, or the configured timeout when it is 0.
+// It must end with Commit or Rollback.
+func (store *Store) BeginPessimisticTransaction(lockTimeout time.Duration) *Txn {
+	if lockTimeout <= 0 {
+		lockTimeout = time.Duration(configs.GetStorageEngineConfig().TransactionConfig.LockTimeoutMs) * time.Millisecond
+	}
+
+	return &Txn{
+		id:          store.lastTransactionID.Add(1),
+		store:       store,
+		batch:       lsmtree.NewWriteBatch(),
+		writes:      make(map[string]*models.Result),
+		keys:        make(map[string]bool),
+		pessimistic: true,
+		lockTimeout: lockTimeout,
+	}
+}
+
+// LockStatus lists the key locks held by pessimistic transactions.
+func (store *Store) LockStatus() []LockInfo {
+	return store.locks.status()
+}
+
+func (txn *Txn) ID() uint64 {
+	return txn.id
+}
+
+func (txn *Txn) Get(key string) (*models.Result, error) {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return nil, ErrTransactionClosed
+	}
+
+	return txn.get(key)
+}
+
+// GetForUpdate reads key like Get and, in a pessimistic transaction, first
+// locks it so nobody else can change it before the transaction ends.
+func (txn *Txn) GetForUpdate(key string) (*models.Result, error) {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return nil, ErrTransactionClosed
+	}
+
+	if err := txn.lock(key); err != nil {
+		return nil, err
+	}
+
+	return txn.get(key)
+}
+
+func (txn *Txn) get(key string) (*models.Result, error) {
+	if result, exists := txn.writes[key]; exists {
+		return result, nil
+	}
+
+	txn.keys[key] = true
+
+	return txn.store.lsmTree.Get(key, txn.snapshot)
+}
+
+// lock takes the lock on key for a pessimistic transaction. A transaction
+// chosen as a deadlock victim is rolled back.
+func (txn *Txn) lock(key string) error {
+	if !txn.pessimistic {
+		return nil
+	}
+
+	err := txn.store.locks.lock(txn.id, key, txn.lockTimeout)
+
+	switch err {
+	case nil:
+		txn.locked = append(txn.locked, key)
+	case ErrDeadlock:
+		txn.end()
+	}
+
+	return err
+}
+
+// end closes the transaction and lets go of its snapshot and locks.
+func (txn *Txn) end() {
+	txn.closed = true
+
+	if txn.snapshot != nil {
+		txn.store.lsmTree.ReleaseSnapshot(txn.snapshot)
+	}
+
+	txn.store.locks.unlock(txn.id, txn.locked)
+}
+
+func (txn *Txn) Put(key string, value string) error {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return ErrTransactionClosed
+	}
+
+	if err := txn.lock(key); err != nil {
+		return err
+	}
+
+	txn.batch.Put(key, value)
+	txn.writes[key] = models.NewFoundResult(value, 0)
+	txn.keys[key] = true
+
+	return nil
+}
+
+func (txn *Txn) Delete(key string) error {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return ErrTransactionClosed
+	}
+
+	if err := txn.lock(key); err != nil {
+		return err
+	}
+
+	txn.batch.Delete(key)
+	txn.writes[key] = models.NewDeletedResult(0)
+	txn.keys[key] = true
+
+	return nil
+}
+
+// Commit applies the buffered writes atomically. An optimistic transaction
+// returns lsmtree.ErrTransactionConflict, and writes nothing, when a key it
+// read or wrote was changed after it began.
+func (txn *Txn) Commit() error {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return ErrTransactionClosed
+	}
+
+	defer txn.end()
+
+	if txn.pessimistic {
+		if err := txn.store.lsmTree.Write(txn.batch); err != nil {
+			return err
+		}
+
+		txn.store.notifyWriteOperation()
+
+		return nil
+	}
+
+	keys := make([]string, 0, len(txn.keys))
+	for key := range txn.keys {
+		keys = append(keys, key)
+	}
+
+	if err := txn.store.lsmTree.WriteIfUnchanged(txn.batch, keys, txn.snapshot.Sequence()); err != nil {
+		return err
+	}
+
+	txn.store.notifyWriteOperation()
+
+	return nil
+}
+
+// Rollback discards the buffered writes.
+func (txn *Txn) Rollback() error {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	if txn.closed {
+		return ErrTransactionClosed
+	}
+
+	txn.end()
+
+	return nil
+}
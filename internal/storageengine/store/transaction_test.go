@@ -0,0 +1,323 @@
+package store
+
+import (
+	"errors"
+	"pkvstore/internal/models"
+	"pkvstore/internal/storageengine/lsmtree"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptimisticTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		// writes in the transaction, then outside it before Commit
+		txn     func(t *testing.T, txn *Txn)
+		outside func(t *testing.T, store *Store)
+		wantErr error
+		want    map[string]string // "" for a missing key
+	}{
+		{
+			name: "commit applies every write",
+			txn: func(t *testing.T, txn *Txn) {
+				mustNotFail(t, txn.Put("a", "1"))
+				mustNotFail(t, txn.Put("b", "2"))
+				mustNotFail(t, txn.Delete("c"))
+			},
+			want: map[string]string{"a": "1", "b": "2", "c": ""},
+		},
+		{
+			name: "key read by the transaction changed",
+			txn: func(t *testing.T, txn *Txn) {
+				if _, err := txn.Get("c"); err != nil {
+					t.Fatal(err)
+				}
+				mustNotFail(t, txn.Put("a", "1"))
+			},
+			outside: func(t *testing.T, store *Store) { mustNotFail(t, store.Put("c", "changed")) },
+			wantErr: lsmtree.ErrTransactionConflict,
+			want:    map[string]string{"a": "", "c": "changed"},
+		},
+		{
+			name:    "key written by the transaction changed",
+			txn:     func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("a", "1")) },
+			outside: func(t *testing.T, store *Store) { mustNotFail(t, store.Put("a", "outside")) },
+			wantErr: lsmtree.ErrTransactionConflict,
+			want:    map[string]string{"a": "outside"},
+		},
+		{
+			name:    "key written by the transaction deleted",
+			txn:     func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("c", "1")) },
+			outside: func(t *testing.T, store *Store) { mustNotFail(t, store.Delete("c")) },
+			wantErr: lsmtree.ErrTransactionConflict,
+			want:    map[string]string{"c": ""},
+		},
+		{
+			name:    "other key changed",
+			txn:     func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("a", "1")) },
+			outside: func(t *testing.T, store *Store) { mustNotFail(t, store.Put("b", "outside")) },
+			want:    map[string]string{"a": "1", "b": "outside"},
+		},
+		{
+			name: "conflicting transaction committed first",
+			txn:  func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("a", "1")) },
+			outside: func(t *testing.T, store *Store) {
+				other := store.BeginTransaction()
+				mustNotFail(t, other.Put("a", "other"))
+				mustNotFail(t, other.Commit())
+			},
+			wantErr: lsmtree.ErrTransactionConflict,
+			want:    map[string]string{"a": "other"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+			mustNotFail(t, store.Put("c", "initial"))
+
+			txn := store.BeginTransaction()
+			test.txn(t, txn)
+
+			if test.outside != nil {
+				test.outside(t, store)
+			}
+
+			if err := txn.Commit(); !errors.Is(err, test.wantErr) {
+				t.Fatalf("Commit: got %v, want %v", err, test.wantErr)
+			}
+
+			for key, want := range test.want {
+				if got := storeValue(t, store, key); got != want {
+					t.Errorf("%q is %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionReads(t *testing.T) {
+	store := newTestStore(t)
+	mustNotFail(t, store.Put("a", "before"))
+	mustNotFail(t, store.Put("b", "before"))
+
+	txn := store.BeginTransaction()
+	defer txn.Rollback()
+
+	mustNotFail(t, store.Put("a", "after"))
+	mustNotFail(t, txn.Put("b", "own"))
+	mustNotFail(t, txn.Delete("c"))
+
+	tests := []struct {
+		key        string
+		wantStatus models.ResultStatus
+		wantValue  string
+	}{
+		{key: "a", wantStatus: models.Found, wantValue: "before"},
+		{key: "b", wantStatus: models.Found, wantValue: "own"},
+		{key: "c", wantStatus: models.Deleted},
+		{key: "d", wantStatus: models.NotFound},
+	}
+
+	for _, test := range tests {
+		result, err := txn.Get(test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.Status != test.wantStatus || result.Value != test.wantValue {
+			t.Errorf("%q: got %+v, want status %d and value %q", test.key, result, test.wantStatus, test.wantValue)
+		}
+	}
+}
+
+func TestTransactionEnds(t *testing.T) {
+	store := newTestStore(t)
+
+	rolledBack := store.BeginTransaction()
+	mustNotFail(t, rolledBack.Put("a", "1"))
+	mustNotFail(t, rolledBack.Rollback())
+
+	if got := storeValue(t, store, "a"); got != "" {
+		t.Errorf("rolled back write is visible: %q", got)
+	}
+
+	committed := store.BeginTransaction()
+	mustNotFail(t, committed.Commit())
+
+	for _, txn := range []*Txn{rolledBack, committed} {
+		if err := txn.Put("a", "2"); !errors.Is(err, ErrTransactionClosed) {
+			t.Errorf("Put: got %v, want %v", err, ErrTransactionClosed)
+		}
+
+		if _, err := txn.Get("a"); !errors.Is(err, ErrTransactionClosed) {
+			t.Errorf("Get: got %v, want %v", err, ErrTransactionClosed)
+		}
+
+		if err := txn.Commit(); !errors.Is(err, ErrTransactionClosed) {
+			t.Errorf("Commit: got %v, want %v", err, ErrTransactionClosed)
+		}
+
+		if err := txn.Rollback(); !errors.Is(err, ErrTransactionClosed) {
+			t.Errorf("Rollback: got %v, want %v", err, ErrTransactionClosed)
+		}
+	}
+}
+
+func TestPessimisticTransaction(t *testing.T) {
+	const shortTimeout = 50 * time.Millisecond
+
+	tests := []struct {
+		name string
+		// run by the first transaction, which keeps its locks
+		first      func(t *testing.T, txn *Txn)
+		wantLocked []string
+		// the second transaction's Put of "a"
+		wantErr error
+	}{
+		{
+			name:       "written key is locked",
+			first:      func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("a", "first")) },
+			wantLocked: []string{"a"},
+			wantErr:    ErrLockTimeout,
+		},
+		{
+			name:       "deleted key is locked",
+			first:      func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Delete("a")) },
+			wantLocked: []string{"a"},
+			wantErr:    ErrLockTimeout,
+		},
+		{
+			name: "key read for update is locked",
+			first: func(t *testing.T, txn *Txn) {
+				if _, err := txn.GetForUpdate("a"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantLocked: []string{"a"},
+			wantErr:    ErrLockTimeout,
+		},
+		{
+			name: "plain read takes no lock",
+			first: func(t *testing.T, txn *Txn) {
+				if _, err := txn.Get("a"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantLocked: []string{},
+		},
+		{
+			name:       "other key",
+			first:      func(t *testing.T, txn *Txn) { mustNotFail(t, txn.Put("b", "first")) },
+			wantLocked: []string{"b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newTestStore(t)
+
+			first := store.BeginPessimisticTransaction(shortTimeout)
+			test.first(t, first)
+
+			locked := make([]string, 0)
+			for _, lock := range store.LockStatus() {
+				if lock.TransactionID != first.ID() {
+					t.Errorf("%q is locked by %d, want %d", lock.Key, lock.TransactionID, first.ID())
+				}
+				locked = append(locked, lock.Key)
+			}
+
+			if !reflect.DeepEqual(locked, test.wantLocked) {
+				t.Errorf("locked %v, want %v", locked, test.wantLocked)
+			}
+
+			second := store.BeginPessimisticTransaction(shortTimeout)
+
+			if err := second.Put("a", "second"); !errors.Is(err, test.wantErr) {
+				t.Fatalf("Put: got %v, want %v", err, test.wantErr)
+			}
+
+			mustNotFail(t, first.Commit())
+
+			if len(store.LockStatus()) != 0 && test.wantErr != nil {
+				t.Errorf("locks left after commit: %v", store.LockStatus())
+			}
+
+			// the lock is free once the first transaction ended
+			mustNotFail(t, second.Put("a", "second"))
+			mustNotFail(t, second.Commit())
+
+			if got := storeValue(t, store, "a"); got != "second" {
+				t.Errorf("a is %q, want %q", got, "second")
+			}
+
+			if len(store.LockStatus()) != 0 {
+				t.Errorf("locks left after both commits: %v", store.LockStatus())
+			}
+		})
+	}
+}
+
+func TestPessimisticTransactionWaitsForLock(t *testing.T) {
+	store := newTestStore(t)
+
+	first := store.BeginPessimisticTransaction(testLockTimeout)
+	mustNotFail(t, first.Put("a", "first"))
+
+	second := store.BeginPessimisticTransaction(testLockTimeout)
+	done := make(chan error, 1)
+
+	go func() {
+		if _, err := second.GetForUpdate("a"); err != nil {
+			done <- err
+			return
+		}
+		done <- second.Put("a", "second")
+	}()
+
+	waitForWaiters(t, store.locks, "a", []uint64{second.ID()})
+	mustNotFail(t, first.Commit())
+	mustNotFail(t, <-done)
+
+	// the waiter sees the write of the transaction it waited for
+	if result, err := second.Get("a"); err != nil || result.Value != "second" {
+		t.Errorf("got %+v, %v", result, err)
+	}
+
+	mustNotFail(t, second.Commit())
+}
+
+func TestPessimisticTransactionDeadlockVictim(t *testing.T) {
+	store := newTestStore(t)
+
+	first := store.BeginPessimisticTransaction(testLockTimeout)
+	second := store.BeginPessimisticTransaction(testLockTimeout)
+
+	mustNotFail(t, first.Put("a", "first"))
+	mustNotFail(t, second.Put("b", "second"))
+
+	done := make(chan error, 1)
+	go func() { done <- first.Put("b", "first") }()
+
+	waitForWaiters(t, store.locks, "b", []uint64{first.ID()})
+
+	// closing the cycle makes the second transaction the victim
+	if err := second.Put("a", "second"); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("got %v, want %v", err, ErrDeadlock)
+	}
+
+	if err := second.Commit(); !errors.Is(err, ErrTransactionClosed) {
+		t.Errorf("victim Commit: got %v, want %v", err, ErrTransactionClosed)
+	}
+
+	mustNotFail(t, <-done)
+	mustNotFail(t, first.Commit())
+
+	for key, want := range map[string]string{"a": "first", "b": "first"} {
+		if got := storeValue(t, store, key); got != want {
+			t.Errorf("%q is %q, want %q", key, got, want)
+		}
+	}
+}
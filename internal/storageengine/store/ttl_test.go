@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"pkvstore/internal/models"
+	"testing"
+	"time"
+)
+
+func TestPutWithTTL(t *testing.T) {
+	store := newTestStore(t)
+
+	mustNotFail(t, store.Put("short", "old"))
+	mustNotFail(t, store.PutWithTTL("short", "new", 20*time.Millisecond))
+	mustNotFail(t, store.PutWithTTL("long", "value", time.Hour))
+
+	if got := storeValue(t, store, "short"); got != "new" {
+		t.Errorf("before it expires: got %q, want new", got)
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	// an expired value hides the older versions like a tombstone
+	if got := storeGet(t, store, "short", nil); got.Status != models.Deleted {
+		t.Errorf("after it expires: got %+v, want deleted", got)
+	}
+
+	if got := storeValue(t, store, "long"); got != "value" {
+		t.Errorf("unexpired key: got %q, want value", got)
+	}
+
+	it := store.NewIterator("", "", nil)
+
+	keys := make([]string, 0)
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	mustNotFail(t, it.Close())
+
+	if len(keys) != 1 || keys[0] != "long" {
+		t.Errorf("iterating: got %q, want [long]", keys)
+	}
+}
+
+func TestPutWithTTLRejectsNonPositiveTTL(t *testing.T) {
+	store := newTestStore(t)
+
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if err := store.PutWithTTL("a", "value", ttl); !errors.Is(err, ErrInvalidTTL) {
+			t.Errorf("ttl %v: got %v, want %v", ttl, err, ErrInvalidTTL)
+		}
+	}
+
+	if got := storeGet(t, store, "a", nil); got.Status != models.NotFound {
+		t.Errorf("got %+v, want not found", got)
+	}
+}
@@ -1,9 +1,16 @@
 package wal
 
 import (
+	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"pkvstore/internal/storageengine/configs"
+	"sync"
+	"time"
 )
 
 // OperationType represents the type of operation in a WAL entry.
@@ -13,85 +20,425 @@ const (
 	InsertOperation OperationType = iota
 	UpdateOperation
 	DeleteOperation
+	// DeleteRangeOperation deletes the keys in [Key, Value) with a range
+	// tombstone. An empty Value leaves the range unbounded above.
+	DeleteRangeOperation
+	// MergeOperation records Value as a merge operand for Key.
+	MergeOperation
 )
 
 // LogEntry represents a single entry in the WAL.
 type LogEntry struct {
+	Sequence  uint64
 	Operation OperationType
 	Key       string
 	Value     string
+	ExpiresAt int64 // Unix time in nanoseconds after which a put reads as deleted, 0 for never
 }
 
-// WriteAheadLog represents the Write-Ahead Log on disk.
+// WriteAheadLog represents the Write-Ahead Log on disk. The log is split into
+// numbered segment files inside one directory; only the newest segment is
+// written to.
 type WriteAheadLog struct {
-	file *os.File
+	directory    string
+	segment      uint64
+	file         *os.File
+	lastSequence uint64
+	syncPolicy   configs.WALSyncPolicy
+	mutex        sync.Mutex
+	stopSync     chan struct{}
 }
 
-// NewWriteAheadLog creates a new Write-Ahead Log instance.
-func NewWriteAheadLog(filename string) (*WriteAheadLog, error) {
-	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+// NewWriteAheadLog creates a new Write-Ahead Log instance writing to the given
+// segment of directory. Entries written to it are numbered after lastSequence.
+func NewWriteAheadLog(directory string, segment uint64, lastSequence uint64) (*WriteAheadLog, error) {
+	config := configs.GetStorageEngineConfig()
+
+	file, err := openSegment(directory, segment)
 	if err != nil {
 		return nil, err
 	}
-	return &WriteAheadLog{
-		file: file,
-	}, nil
+
+	wal := &WriteAheadLog{
+		directory:    directory,
+		segment:      segment,
+		file:         file,
+		lastSequence: lastSequence,
+		syncPolicy:   config.WALConfig.SyncPolicy,
+		stopSync:     make(chan struct{}),
+	}
+
+	if wal.syncPolicy == configs.SyncInterval {
+		go wal.syncPeriodically(time.Duration(config.WALConfig.SyncIntervalMs) * time.Millisecond)
+	}
+
+	return wal, nil
+}
+
+func openSegment(directory string, segment uint64) (*os.File, error) {
+	file, err := os.OpenFile(SegmentFileName(directory, segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		if _, err := file.Write(encodeHeader()); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
 }
 
-// WriteEntry appends a LogEntry to the Write-Ahead Log on disk.
+// WriteEntry stamps the LogEntry with the next sequence number and appends it
+// to the Write-Ahead Log on disk.
 func (wal *WriteAheadLog) WriteEntry(entry LogEntry) error {
-	if err := binary.Write(wal.file, binary.LittleEndian, entry.Operation); err != nil {
-		return err
+	return wal.WriteBatches([][]*LogEntry{{&entry}})
+}
+
+// WriteBatches stamps the LogEntries with consecutive sequence numbers and
+// appends them to the Write-Ahead Log with a single write. Each batch is one
+// record, so replay applies all of it or none of it; empty batches are
+// skipped. Under the SyncEveryWrite policy the segment is fsynced once for the
+// whole group.
+func (wal *WriteAheadLog) WriteBatches(batches [][]*LogEntry) error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	buf := make([]byte, 0)
+	sequence := wal.lastSequence
+
+	for _, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+
+		for _, entry := range batch {
+			sequence++
+			entry.Sequence = sequence
+		}
+
+		buf = encodeRecord(buf, batch)
 	}
 
-	keyLength := uint32(len(entry.Key))
-	if err := binary.Write(wal.file, binary.LittleEndian, keyLength); err != nil {
+	if _, err := wal.file.Write(buf); err != nil {
 		return err
 	}
 
-	if _, err := wal.file.Write([]byte(entry.Key)); err != nil {
-		return err
+	if wal.syncPolicy == configs.SyncEveryWrite {
+		if err := wal.file.Sync(); err != nil {
+			return err
+		}
 	}
 
-	valueLength := uint32(len(entry.Value))
-	if err := binary.Write(wal.file, binary.LittleEndian, valueLength); err != nil {
+	wal.lastSequence = sequence
+
+	return nil
+}
+
+func (wal *WriteAheadLog) syncPeriodically(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wal.mutex.Lock()
+			if err := wal.file.Sync(); err != nil {
+				log.Println("Syncing WAL segment:", err)
+			}
+			wal.mutex.Unlock()
+		case <-wal.stopSync:
+			return
+		}
+	}
+}
+
+// LastSequence returns the sequence number of the last entry written.
+func (wal *WriteAheadLog) LastSequence() uint64 {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	return wal.lastSequence
+}
+
+// AdvanceSequence numbers the entries written from now on after sequence when
+// it is ahead of the log, as after the segments holding the newest writes were
+// flushed and removed.
+func (wal *WriteAheadLog) AdvanceSequence(sequence uint64) {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if sequence > wal.lastSequence {
+		wal.lastSequence = sequence
+	}
+}
+
+// CurrentSegment returns the number of the segment being written to.
+func (wal *WriteAheadLog) CurrentSegment() uint64 {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	return wal.segment
+}
+
+// Rotate syncs and closes the current segment and starts writing to the next
+// one. On failure the log keeps writing to the current segment.
+func (wal *WriteAheadLog) Rotate() error {
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.syncPolicy != configs.NoSync {
+		if err := wal.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	file, err := openSegment(wal.directory, wal.segment+1)
+	if err != nil {
 		return err
 	}
 
-	if _, err := wal.file.Write([]byte(entry.Value)); err != nil {
+	if err := wal.file.Close(); err != nil {
+		file.Close()
 		return err
 	}
 
+	wal.file = file
+	wal.segment++
+
 	return nil
 }
 
-// Close closes the Write-Ahead Log file.
+// Close syncs and closes the Write-Ahead Log file.
 func (wal *WriteAheadLog) Close() error {
+	close(wal.stopSync)
+
+	wal.mutex.Lock()
+	defer wal.mutex.Unlock()
+
+	if wal.syncPolicy != configs.NoSync {
+		if err := wal.file.Sync(); err != nil {
+			wal.file.Close()
+			return err
+		}
+	}
+
 	return wal.file.Close()
 }
 
-func main() {
-	// Example Usage:
+// WalReader reads LogEntries sequentially from a Write-Ahead Log file.
+type WalReader struct {
+	file     *os.File
+	reader   *bufio.Reader
+	size     int64
+	offset   int64
+	isHeader bool
+
+	// offset of the record ReadBatch read last, 0 while the header is read
+	recordStart int64
+}
 
-	// Create a Write-Ahead Log
-	wal, err := NewWriteAheadLog("write_ahead_log.bin")
+// NewWalReader opens a Write-Ahead Log file for reading.
+func NewWalReader(filename string) (*WalReader, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		fmt.Println("Error creating Write-Ahead Log:", err)
-		return
+		return nil, err
 	}
-	defer wal.Close()
 
-	// Write entries to the Write-Ahead Log
-	entries := []LogEntry{
-		{Operation: InsertOperation, Key: "key1", Value: "value1"},
-		{Operation: UpdateOperation, Key: "key2", Value: "value2"},
-		{Operation: DeleteOperation, Key: "key3", Value: ""},
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	for _, entry := range entries {
-		if err := wal.WriteEntry(entry); err != nil {
-			fmt.Println("Error writing to Write-Ahead Log:", err)
-			return
+	return &WalReader{
+		file:     file,
+		reader:   bufio.NewReader(file),
+		size:     info.Size(),
+		offset:   0,
+		isHeader: true,
+	}, nil
+}
+
+// ReadBatch reads the LogEntries of the next record. It returns io.EOF when
+// the log ends on a record boundary, io.ErrUnexpectedEOF when the final record
+// is torn and ErrCorruptedRecord when a record fails its checksum or its
+// length runs past the end of the file with valid records behind it. After a
+// corrupted record the reader moves on to the next valid one, so the next call
+// continues there.
+func (reader *WalReader) ReadBatch() ([]*LogEntry, error) {
+	if reader.isHeader {
+		if err := reader.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	recordStart := reader.offset
+	reader.recordStart = recordStart
+
+	recordHeader := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(reader.reader, recordHeader); err != nil {
+		return nil, err
+	}
+
+	checksum := binary.LittleEndian.Uint32(recordHeader[0:4])
+	length := int64(binary.LittleEndian.Uint32(recordHeader[4:8]))
+
+	if recordStart+recordHeaderSize+length > reader.size {
+		// only the last record can be cut short by a crash
+		found, err := reader.skipToValidRecord(recordStart + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if found {
+			return nil, ErrCorruptedRecord
+		}
+
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader.reader, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	reader.offset += recordHeaderSize + length
+
+	if !validRecord(checksum, recordHeader[4:8], payload) {
+		// the length may be the corrupted part, so the next record is looked
+		// for right after the start of this one
+		if _, err := reader.skipToValidRecord(recordStart + 1); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrCorruptedRecord
+	}
+
+	return decodePayload(payload)
+}
+
+// skipToValidRecord moves the reader to the first offset from from on where a
+// record with a matching checksum starts, or to the end of the file when
+// there is none, and reports whether it found one.
+func (reader *WalReader) skipToValidRecord(from int64) (bool, error) {
+	rest, err := io.ReadAll(io.NewSectionReader(reader.file, from, reader.size-from))
+	if err != nil {
+		return false, err
+	}
+
+	next, found := reader.size, false
+
+	for position := 0; position+recordHeaderSize <= len(rest); position++ {
+		length := int(binary.LittleEndian.Uint32(rest[position+4 : position+8]))
+		end := position + recordHeaderSize + length
+
+		if end > len(rest) || end < position {
+			continue
+		}
+
+		checksum := binary.LittleEndian.Uint32(rest[position : position+4])
+		if validRecord(checksum, rest[position+4:position+8], rest[position+recordHeaderSize:end]) {
+			next, found = from+int64(position), true
+			break
+		}
+	}
+
+	if _, err := reader.file.Seek(next, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	reader.reader.Reset(reader.file)
+	reader.offset = next
+
+	return found, nil
+}
+
+func (reader *WalReader) readHeader() error {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(reader.reader, header); err != nil {
+		return err
+	}
+
+	if err := decodeHeader(header); err != nil {
+		return err
+	}
+
+	reader.offset = walHeaderSize
+	reader.isHeader = false
+
+	return nil
+}
+
+// Offset returns the file offset just past the last record consumed.
+func (reader *WalReader) Offset() int64 {
+	return reader.offset
+}
+
+// Close closes the underlying file.
+func (reader *WalReader) Close() error {
+	return reader.file.Close()
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// replaySegment calls apply for every valid LogEntry in one segment file and
+// returns the last sequence number it saw. A torn final record is truncated
+// away, except under FailOnCorruption, which only skips it; a corrupted record
+// is handled according to mode. stopped reports that replay
+// must not continue with later segments.
+func replaySegment(filename string, mode configs.WALRecoveryMode, apply func(entry *LogEntry)) (lastSequence uint64, stopped bool, err error) {
+	reader, err := NewWalReader(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	defer reader.Close()
+
+	for {
+		batch, err := reader.ReadBatch()
+		recordStart := reader.recordStart
+
+		switch {
+		case err == io.EOF:
+			return lastSequence, false, nil
+		case err == io.ErrUnexpectedEOF && mode == configs.FailOnCorruption:
+			// the torn record was never acknowledged; it is left in place
+			// and ignored, as this mode never changes the log
+			return lastSequence, false, nil
+		case err == io.ErrUnexpectedEOF:
+			return lastSequence, false, os.Truncate(filename, recordStart)
+		case errors.Is(err, ErrCorruptedRecord):
+			switch mode {
+			case configs.SkipCorruptedRecords:
+				continue
+			case configs.StopAtFirstCorruption:
+				return lastSequence, true, os.Truncate(filename, recordStart)
+			default:
+				return lastSequence, true, fmt.Errorf("%w at offset %d of %s", err, recordStart, filename)
+			}
+		case err != nil:
+			return lastSequence, true, err
+		}
+
+		for _, entry := range batch {
+			if entry.Sequence > lastSequence {
+				lastSequence = entry.Sequence
+			}
+
+			apply(entry)
 		}
 	}
 }
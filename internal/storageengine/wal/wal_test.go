@@ -0,0 +1,412 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"pkvstore/internal/storageengine/configs"
+	"reflect"
+	"testing"
+)
+
+func TestRecordRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []*LogEntry
+	}{
+		{
+			name:    "single put",
+			entries: []*LogEntry{{Sequence: 1, Operation: InsertOperation, Key: "k", Value: "v"}},
+		},
+		{
+			name: "batch of every operation",
+			entries: []*LogEntry{
+				{Sequence: 7, Operation: InsertOperation, Key: "a", Value: "1"},
+				{Sequence: 8, Operation: DeleteOperation, Key: "b"},
+				{Sequence: 9, Operation: DeleteRangeOperation, Key: "c", Value: "d"},
+				{Sequence: 10, Operation: MergeOperation, Key: "e", Value: "2"},
+			},
+		},
+		{
+			name: "expiring put",
+			entries: []*LogEntry{
+				{Sequence: 3, Operation: InsertOperation, Key: "a", Value: "1", ExpiresAt: 1700000000000000000},
+				{Sequence: 4, Operation: InsertOperation, Key: "b", Value: "2"},
+			},
+		},
+		{
+			name:    "empty key and value",
+			entries: []*LogEntry{{Sequence: 4, Operation: InsertOperation}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			record := encodeRecord(nil, test.entries)
+			payload := record[recordHeaderSize:]
+
+			if !validRecord(binary.LittleEndian.Uint32(record[0:4]), record[4:8], payload) {
+				t.Fatal("checksum of the encoded record does not match")
+			}
+
+			decoded, err := decodePayload(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(decoded, test.entries) {
+				t.Errorf("decoded %+v, want %+v", decoded, test.entries)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadRejectsTruncatedBatch(t *testing.T) {
+	record := encodeRecord(nil, []*LogEntry{
+		{Sequence: 1, Operation: InsertOperation, Key: "key", Value: "value"},
+		{Sequence: 2, Operation: DeleteOperation, Key: "other"},
+	})
+	payload := record[recordHeaderSize:]
+
+	for length := 0; length < len(payload); length++ {
+		if _, err := decodePayload(payload[:length]); !errors.Is(err, ErrCorruptedRecord) {
+			t.Errorf("payload cut to %d bytes: got %v, want ErrCorruptedRecord", length, err)
+		}
+	}
+}
+
+// A batch is one record, so a damaged batch is skipped as a whole.
+func TestReplaySkipsWholeBatch(t *testing.T) {
+	directory := t.TempDir()
+
+	wal, err := NewWriteAheadLog(directory, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	batches := [][]*LogEntry{
+		{{Operation: InsertOperation, Key: "a"}, {Operation: InsertOperation, Key: "b"}},
+		{{Operation: InsertOperation, Key: "c"}, {Operation: DeleteOperation, Key: "a"}},
+		{{Operation: InsertOperation, Key: "d"}},
+	}
+
+	if err := wal.WriteBatches(batches); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	filename := SegmentFileName(directory, 1)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last byte of the second batch
+	secondBatch := walHeaderSize + 2*recordSize(data) - 1
+
+	if err := os.WriteFile(filename, flipBit(func(data []byte) int { return secondBatch })(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := make([]string, 0)
+
+	lastSequence, _, err := Replay(directory, configs.SkipCorruptedRecords, func(entry *LogEntry) {
+		replayed = append(replayed, fmt.Sprint(entry.Key, "@", entry.Sequence))
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"a@1", "b@2", "d@5"}; !reflect.DeepEqual(replayed, want) {
+		t.Errorf("replayed %v, want %v", replayed, want)
+	}
+
+	if lastSequence != 5 {
+		t.Errorf("last sequence %d, want 5", lastSequence)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	const numberOfRecords = 5
+
+	// the log starts with the file header
+	secondRecord := func(data []byte) int { return walHeaderSize + recordSize(data) }
+	secondLength := func(data []byte) int { return secondRecord(data) + 4 }
+	secondLengthHighByte := func(data []byte) int { return secondLength(data) + 3 }
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+		mode    configs.WALRecoveryMode
+		applied int
+		wantErr error
+		// size of the segment after replay, nil for unchanged
+		wantSize func(data []byte) int
+	}{
+		{
+			name:    "intact",
+			corrupt: func(data []byte) []byte { return data },
+			mode:    configs.FailOnCorruption,
+			applied: numberOfRecords,
+		},
+		{
+			name:     "torn tail is truncated",
+			corrupt:  func(data []byte) []byte { return data[:len(data)-3] },
+			mode:     configs.StopAtFirstCorruption,
+			applied:  numberOfRecords - 1,
+			wantSize: func(data []byte) int { return len(data) - recordSize(data) },
+		},
+		{
+			name:    "torn tail is left alone when failing on corruption",
+			corrupt: func(data []byte) []byte { return data[:len(data)-3] },
+			mode:    configs.FailOnCorruption,
+			applied: numberOfRecords - 1,
+		},
+		{
+			name:    "bad checksum fails",
+			corrupt: flipBit(secondRecord),
+			mode:    configs.FailOnCorruption,
+			applied: 1,
+			wantErr: ErrCorruptedRecord,
+		},
+		{
+			name:    "bad checksum is skipped",
+			corrupt: flipBit(secondRecord),
+			mode:    configs.SkipCorruptedRecords,
+			applied: numberOfRecords - 1,
+		},
+		{
+			name:     "bad checksum stops replay",
+			corrupt:  flipBit(secondRecord),
+			mode:     configs.StopAtFirstCorruption,
+			applied:  1,
+			wantSize: secondRecord,
+		},
+		{
+			name:    "length past the end with records behind it fails",
+			corrupt: flipBit(secondLengthHighByte),
+			mode:    configs.FailOnCorruption,
+			applied: 1,
+			wantErr: ErrCorruptedRecord,
+		},
+		{
+			name:    "length past the end with records behind it is skipped",
+			corrupt: flipBit(secondLengthHighByte),
+			mode:    configs.SkipCorruptedRecords,
+			applied: numberOfRecords - 1,
+		},
+		{
+			name:    "length inside the file is skipped",
+			corrupt: flipBit(secondLength),
+			mode:    configs.SkipCorruptedRecords,
+			applied: numberOfRecords - 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			data := writeSegment(t, directory, numberOfRecords)
+			filename := SegmentFileName(directory, 1)
+
+			if err := os.WriteFile(filename, test.corrupt(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			before := fileSize(t, filename)
+			applied := 0
+
+			_, _, err := Replay(directory, test.mode, func(entry *LogEntry) { applied++ })
+
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v, want %v", err, test.wantErr)
+			}
+
+			if applied != test.applied {
+				t.Errorf("applied %d entries, want %d", applied, test.applied)
+			}
+
+			wantSize := before
+			if test.wantSize != nil {
+				wantSize = int64(test.wantSize(data))
+			}
+
+			if size := fileSize(t, filename); size != wantSize {
+				t.Errorf("segment is %d bytes after replay, want %d", size, wantSize)
+			}
+		})
+	}
+}
+
+func TestReplayContinuesWithNextSegment(t *testing.T) {
+	directory := t.TempDir()
+	writeSegments(t, directory, 3, 2)
+
+	sequences := make([]uint64, 0)
+
+	lastSequence, lastSegment, err := Replay(directory, configs.FailOnCorruption, func(entry *LogEntry) {
+		sequences = append(sequences, entry.Sequence)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []uint64{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(sequences, want) {
+		t.Errorf("replayed sequences %v, want %v", sequences, want)
+	}
+
+	if lastSequence != 6 || lastSegment != 3 {
+		t.Errorf("got last sequence %d and segment %d, want 6 and 3", lastSequence, lastSegment)
+	}
+
+	if _, _, err := Replay(t.TempDir(), configs.FailOnCorruption, nil); err != nil {
+		t.Errorf("replaying an empty directory: %v", err)
+	}
+}
+
+func TestReplayStopRemovesLaterSegments(t *testing.T) {
+	directory := t.TempDir()
+	writeSegments(t, directory, 3, 2)
+
+	filename := SegmentFileName(directory, 2)
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filename, flipBit(func(data []byte) int { return walHeaderSize })(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := 0
+
+	lastSequence, _, err := Replay(directory, configs.StopAtFirstCorruption, func(entry *LogEntry) { applied++ })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied != 2 || lastSequence != 2 {
+		t.Errorf("applied %d entries up to %d, want 2 up to 2", applied, lastSequence)
+	}
+
+	if segments, _ := ListSegments(directory); !reflect.DeepEqual(segments, []uint64{1, 2}) {
+		t.Errorf("segments left %v, want [1 2]", segments)
+	}
+}
+
+func TestRemoveSegments(t *testing.T) {
+	tests := []struct {
+		name         string
+		archive      bool
+		upTo         uint64
+		wantLeft     []uint64
+		wantArchived []uint64
+	}{
+		{name: "delete", upTo: 2, wantLeft: []uint64{3}, wantArchived: []uint64{}},
+		{name: "archive", archive: true, upTo: 2, wantLeft: []uint64{3}, wantArchived: []uint64{1, 2}},
+		{name: "none", upTo: 0, wantLeft: []uint64{1, 2, 3}, wantArchived: []uint64{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory, archiveDirectory := t.TempDir(), t.TempDir()
+			writeSegments(t, directory, 3, 1)
+
+			archive := ""
+			if test.archive {
+				archive = archiveDirectory
+			}
+
+			if err := RemoveSegments(directory, test.upTo, archive); err != nil {
+				t.Fatal(err)
+			}
+
+			if left, _ := ListSegments(directory); !reflect.DeepEqual(left, test.wantLeft) {
+				t.Errorf("segments left %v, want %v", left, test.wantLeft)
+			}
+
+			if archived, _ := ListSegments(archiveDirectory); !reflect.DeepEqual(archived, test.wantArchived) {
+				t.Errorf("segments archived %v, want %v", archived, test.wantArchived)
+			}
+		})
+	}
+}
+
+// writeSegments writes segments 1 to count of entries records each, numbered
+// across the segments as rotation numbers them.
+func writeSegments(t *testing.T, directory string, count int, entries int) {
+	for segment := uint64(1); segment <= uint64(count); segment++ {
+		wal, err := NewWriteAheadLog(directory, segment, (segment-1)*uint64(entries))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < entries; i++ {
+			if err := wal.WriteEntry(LogEntry{Operation: InsertOperation, Key: fmt.Sprint(segment, i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := wal.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSegment writes records of one entry each to segment 1 of directory and
+// returns the file.
+func writeSegment(t *testing.T, directory string, records int) []byte {
+	wal, err := NewWriteAheadLog(directory, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < records; i++ {
+		if err := wal.WriteEntry(LogEntry{Operation: InsertOperation, Key: fmt.Sprint("key", i), Value: "value"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(SegmentFileName(directory, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// recordSize returns the size of the records written by writeSegment, which
+// are all alike.
+func recordSize(data []byte) int {
+	return recordHeaderSize + int(binary.LittleEndian.Uint32(data[walHeaderSize+4:walHeaderSize+8]))
+}
+
+// flipBit damages the byte at offset(data).
+func flipBit(offset func(data []byte) int) func(data []byte) []byte {
+	return func(data []byte) []byte {
+		data[offset(data)] ^= 0x10
+		return data
+	}
+}
+
+func fileSize(t *testing.T, filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.Size()
+}
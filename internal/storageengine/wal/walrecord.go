@@ -0,0 +1,174 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// A WAL file starts with a header holding the magic number and the format
+// version, followed by records laid out as:
+//
+//	checksum (uint32) | length (uint32) | payload (length bytes)
+//
+// where the payload holds a batch of entries that is applied atomically:
+//
+//	first sequence (uint64) | number of entries (uint32) | entry 1 | ... | entry n
+//	entry: operation (byte) | key length (uint32) | key | value length (uint32) | value | [expires at (int64)]
+//
+// and the entries are numbered consecutively from the first sequence. The
+// expiry is present when the high bit of the operation is set. The checksum is a CRC32C over the length and the payload.
+const (
+	WAL_MAGIC   uint32 = 0x57564b50 // "PKVW"
+	WAL_VERSION uint8  = 1
+
+	walHeaderSize    = 4 + 1
+	recordHeaderSize = 4 + 4
+	batchHeaderSize  = 8 + 4
+	entryFixedSize   = 1 + 4 + 4
+	expirySize       = 8
+
+	// set on the operation byte of entries followed by an expiry
+	expiryFlag byte = 0x80
+)
+
+var (
+	ErrCorruptedRecord    = errors.New("wal: corrupted record")
+	ErrUnsupportedVersion = errors.New("wal: unsupported format version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func encodeHeader() []byte {
+	header := make([]byte, walHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], WAL_MAGIC)
+	header[4] = WAL_VERSION
+	return header
+}
+
+// decodeHeader checks the magic number and format version of a WAL file.
+func decodeHeader(header []byte) error {
+	if binary.LittleEndian.Uint32(header[0:4]) != WAL_MAGIC || header[4] != WAL_VERSION {
+		return ErrUnsupportedVersion
+	}
+	return nil
+}
+
+// encodeRecord appends the record for a batch of entries, already numbered
+// consecutively, to buf and returns the extended buffer.
+func encodeRecord(buf []byte, entries []*LogEntry) []byte {
+	length := batchHeaderSize
+
+	for _, entry := range entries {
+		length += entryFixedSize + len(entry.Key) + len(entry.Value)
+
+		if entry.ExpiresAt != 0 {
+			length += expirySize
+		}
+	}
+
+	start := len(buf)
+	buf = binary.LittleEndian.AppendUint32(buf, 0)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(length))
+
+	buf = binary.LittleEndian.AppendUint64(buf, entries[0].Sequence)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entries)))
+
+	for _, entry := range entries {
+		operation := byte(entry.Operation)
+		if entry.ExpiresAt != 0 {
+			operation |= expiryFlag
+		}
+
+		buf = append(buf, operation)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.Key)))
+		buf = append(buf, entry.Key...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(entry.Value)))
+		buf = append(buf, entry.Value...)
+
+		if entry.ExpiresAt != 0 {
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(entry.ExpiresAt))
+		}
+	}
+
+	record := buf[start:]
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(record[4:], crcTable))
+
+	return buf
+}
+
+// validRecord reports whether checksum matches the length field and payload of
+// a record.
+func validRecord(checksum uint32, length []byte, payload []byte) bool {
+	return crc32.Update(crc32.Checksum(length, crcTable), crcTable, payload) == checksum
+}
+
+// decodePayload decodes the batch in a record.
+func decodePayload(payload []byte) ([]*LogEntry, error) {
+	if len(payload) < batchHeaderSize {
+		return nil, ErrCorruptedRecord
+	}
+
+	sequence := binary.LittleEndian.Uint64(payload[0:8])
+	count := int(binary.LittleEndian.Uint32(payload[8:12]))
+	payload = payload[batchHeaderSize:]
+
+	if count*entryFixedSize > len(payload) {
+		return nil, ErrCorruptedRecord
+	}
+
+	entries := make([]*LogEntry, 0, count)
+
+	for i := 0; i < count; i++ {
+		if len(payload) < 1 {
+			return nil, ErrCorruptedRecord
+		}
+
+		entry := &LogEntry{
+			Sequence:  sequence + uint64(i),
+			Operation: OperationType(payload[0] &^ expiryFlag),
+		}
+		hasExpiry := payload[0]&expiryFlag != 0
+
+		var err error
+
+		if entry.Key, payload, err = readString(payload[1:]); err != nil {
+			return nil, err
+		}
+
+		if entry.Value, payload, err = readString(payload); err != nil {
+			return nil, err
+		}
+
+		if hasExpiry {
+			if len(payload) < expirySize {
+				return nil, ErrCorruptedRecord
+			}
+
+			entry.ExpiresAt = int64(binary.LittleEndian.Uint64(payload[0:expirySize]))
+			payload = payload[expirySize:]
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(payload) != 0 {
+		return nil, ErrCorruptedRecord
+	}
+
+	return entries, nil
+}
+
+// readString decodes a length-prefixed string and returns the bytes after it.
+func readString(payload []byte) (string, []byte, error) {
+	if len(payload) < 4 {
+		return "", nil, ErrCorruptedRecord
+	}
+
+	length := int(binary.LittleEndian.Uint32(payload[0:4]))
+	if 4+length > len(payload) {
+		return "", nil, ErrCorruptedRecord
+	}
+
+	return string(payload[4 : 4+length]), payload[4+length:], nil
+}
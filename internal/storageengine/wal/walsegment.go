@@ -0,0 +1,117 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"pkvstore/internal/storageengine/configs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const SEGMENT_EXTENSION = ".log"
+
+// SegmentFileName returns the path of a numbered segment inside directory.
+func SegmentFileName(directory string, segment uint64) string {
+	return filepath.Join(directory, fmt.Sprintf("%06d%s", segment, SEGMENT_EXTENSION))
+}
+
+// ListSegments returns the numbers of the segments in directory in ascending order.
+func ListSegments(directory string) ([]uint64, error) {
+	dirEntries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]uint64, 0)
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+
+		if dirEntry.IsDir() || !strings.HasSuffix(name, SEGMENT_EXTENSION) {
+			continue
+		}
+
+		segment, err := strconv.ParseUint(strings.TrimSuffix(name, SEGMENT_EXTENSION), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		segments = append(segments, segment)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+
+	return segments, nil
+}
+
+// RemoveSegments drops every segment numbered up to and including upTo. When
+// archiveDirectory is set the segments are moved there instead of deleted.
+func RemoveSegments(directory string, upTo uint64, archiveDirectory string) error {
+	segments, err := ListSegments(directory)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if segment > upTo {
+			break
+		}
+
+		filename := SegmentFileName(directory, segment)
+
+		if archiveDirectory == "" {
+			err = os.Remove(filename)
+		} else {
+			err = os.Rename(filename, SegmentFileName(archiveDirectory, segment))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replay calls apply for every valid LogEntry in the segments of directory, in
+// order, and returns the last sequence number and the last segment number it
+// saw. When replay stops at a corrupted record, the later segments are removed
+// so that they are not replayed on top of the gap next time.
+func Replay(directory string, mode configs.WALRecoveryMode, apply func(entry *LogEntry)) (lastSequence uint64, lastSegment uint64, err error) {
+	segments, err := ListSegments(directory)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i, segment := range segments {
+		lastSegment = segment
+
+		segmentSequence, stopped, err := replaySegment(SegmentFileName(directory, segment), mode, apply)
+
+		if segmentSequence > lastSequence {
+			lastSequence = segmentSequence
+		}
+
+		if err != nil {
+			return lastSequence, lastSegment, err
+		}
+
+		if !stopped {
+			continue
+		}
+
+		for _, laterSegment := range segments[i+1:] {
+			if err := os.Remove(SegmentFileName(directory, laterSegment)); err != nil {
+				return lastSequence, lastSegment, err
+			}
+		}
+
+		break
+	}
+
+	return lastSequence, lastSegment, nil
+}
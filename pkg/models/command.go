@@ -1,8 +1,11 @@
 package models
 
+import "time"
+
 type PutCommand struct {
 	Key   string
 	Value string
+	TTL   time.Duration // how long the item lives, 0 for ever
 }
 
 type GetCommand struct {
@@ -12,3 +15,121 @@ type GetCommand struct {
 type DeleteCommand struct {
 	Key string
 }
+
+// DeleteRangeCommand deletes the keys in [Start, End), or every key from
+// Start on when End is empty, or the keys under Prefix when it is set. At
+// least one of them must be set unless All asks for every key.
+type DeleteRangeCommand struct {
+	Start  string
+	End    string
+	Prefix string
+	All    bool
+}
+
+// MergeCommand combines Operand with the value of Key using the server's
+// merge operator, such as adding it to a counter.
+type MergeCommand struct {
+	Key     string
+	Operand string
+}
+
+// CompareAndSwapCommand sets Key to NewValue only if it holds ExpectedValue.
+type CompareAndSwapCommand struct {
+	Key           string
+	ExpectedValue string
+	NewValue      string
+}
+
+// PutIfAbsentCommand sets Key to Value only if Key does not exist.
+type PutIfAbsentCommand struct {
+	Key   string
+	Value string
+}
+
+// DeleteIfEqualsCommand deletes Key only if it holds ExpectedValue.
+type DeleteIfEqualsCommand struct {
+	Key           string
+	ExpectedValue string
+}
+
+type BatchOperationType int
+
+const (
+	BatchPut BatchOperationType = iota
+	BatchDelete
+	BatchDeleteRange // deletes the keys in [Key, End)
+)
+
+type BatchOperation struct {
+	Type  BatchOperationType
+	Key   string
+	Value string
+	End   string
+}
+
+// WriteBatchCommand applies its operations atomically, in order.
+type WriteBatchCommand struct {
+	Operations []BatchOperation
+}
+
+// BeginTransactionCommand starts an optimistic transaction, or a pessimistic
+// one that locks the keys it touches when Pessimistic is set. A zero
+// LockTimeoutMs uses the server's default lock timeout.
+type BeginTransactionCommand struct {
+	Pessimistic   bool
+	LockTimeoutMs int
+}
+
+// TransactionCommand addresses a transaction started with
+// BeginTransactionCommand. Key and Value are used by reads and writes only;
+// ForUpdate makes a read lock the key in a pessimistic transaction.
+type TransactionCommand struct {
+	TransactionID uint64
+	Key           string
+	Value         string
+	ForUpdate     bool
+}
+
+type LockStatusCommand struct {
+}
+
+// LockInfo names the transaction holding the lock on Key and the ones
+// waiting for it.
+type LockInfo struct {
+	Key           string
+	TransactionID uint64
+	Waiters       []uint64
+}
+
+// ScanCommand lists keys in [Start, End), or the keys under Prefix when it is
+// set. A zero Limit returns every key.
+type ScanCommand struct {
+	Start  string
+	End    string
+	Prefix string
+	Limit  int
+}
+
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+type StatsCommand struct {
+}
+
+// Stats reports the shape of the store and how writes have been stalled.
+type Stats struct {
+	ActiveMemtableSize int
+	ImmutableMemtables int
+	SSTablesPerLevel   []int
+	BytesPerLevel      []uint64
+	CompactionStyle    string
+	DroppedSSTables    uint64
+	DroppedBytes       uint64
+	WriteStallState    string
+	DelayedWrites      uint64
+	StoppedWrites      uint64
+	DelayedDuration    time.Duration
+	StoppedDuration    time.Duration
+}
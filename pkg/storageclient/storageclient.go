@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/rpc"
 	"pkvstore/pkg/models"
+	"time"
 )
 
 type StorageClient struct {
@@ -36,6 +37,22 @@ func (s *StorageClient) Put(key string, value string) bool {
 	return putReply
 }
 
+// PutWithTTL writes an item that expires once ttl has passed.
+func (s *StorageClient) PutWithTTL(key string, value string, ttl time.Duration) bool {
+
+	putItem := models.PutCommand{Key: key, Value: value, TTL: ttl}
+
+	var putReply bool
+
+	err := s.client.Call("StorageServer.Put", putItem, &putReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.Put error:", err)
+	}
+
+	return putReply
+}
+
 func (s *StorageClient) Get(key string) string {
 
 	getItem := models.GetCommand{Key: key}
@@ -65,3 +82,227 @@ func (s *StorageClient) Delete(key string) bool {
 
 	return deleteReply
 }
+
+// DeleteRange deletes the keys of a range or a prefix with a single range
+// tombstone.
+func (s *StorageClient) DeleteRange(command models.DeleteRangeCommand) bool {
+
+	var deleteReply bool
+
+	err := s.client.Call("StorageServer.DeleteRange", command, &deleteReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.DeleteRange error:", err)
+	}
+
+	return deleteReply
+}
+
+// Merge combines operand with the value of key on the server in one round
+// trip. It returns the server's error, such as an operand the merge operator
+// rejects.
+func (s *StorageClient) Merge(key string, operand string) error {
+
+	mergeItem := models.MergeCommand{Key: key, Operand: operand}
+
+	var mergeReply bool
+
+	return s.client.Call("StorageServer.Merge", mergeItem, &mergeReply)
+}
+
+// CompareAndSwap sets key to newValue if it holds expectedValue and reports
+// whether it did.
+func (s *StorageClient) CompareAndSwap(key string, expectedValue string, newValue string) bool {
+
+	casItem := models.CompareAndSwapCommand{Key: key, ExpectedValue: expectedValue, NewValue: newValue}
+
+	var casReply bool
+
+	err := s.client.Call("StorageServer.CompareAndSwap", casItem, &casReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.CompareAndSwap error:", err)
+	}
+
+	return casReply
+}
+
+// PutIfAbsent sets key to value if it does not exist and reports whether it
+// did.
+func (s *StorageClient) PutIfAbsent(key string, value string) bool {
+
+	putItem := models.PutIfAbsentCommand{Key: key, Value: value}
+
+	var putReply bool
+
+	err := s.client.Call("StorageServer.PutIfAbsent", putItem, &putReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.PutIfAbsent error:", err)
+	}
+
+	return putReply
+}
+
+// DeleteIfEquals deletes key if it holds expectedValue and reports whether it
+// did.
+func (s *StorageClient) DeleteIfEquals(key string, expectedValue string) bool {
+
+	deleteItem := models.DeleteIfEqualsCommand{Key: key, ExpectedValue: expectedValue}
+
+	var deleteReply bool
+
+	err := s.client.Call("StorageServer.DeleteIfEquals", deleteItem, &deleteReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.DeleteIfEquals error:", err)
+	}
+
+	return deleteReply
+}
+
+// WriteBatch applies the operations atomically on the server.
+func (s *StorageClient) WriteBatch(operations []models.BatchOperation) bool {
+
+	batchItem := models.WriteBatchCommand{Operations: operations}
+
+	var batchReply bool
+
+	err := s.client.Call("StorageServer.WriteBatch", batchItem, &batchReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.WriteBatch error:", err)
+	}
+
+	return batchReply
+}
+
+func (s *StorageClient) Scan(command models.ScanCommand) []models.KeyValue {
+
+	var scanReply []models.KeyValue
+
+	err := s.client.Call("StorageServer.Scan", command, &scanReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.Scan error:", err)
+	}
+
+	return scanReply
+}
+
+func (s *StorageClient) Stats() models.Stats {
+
+	var statsReply models.Stats
+
+	err := s.client.Call("StorageServer.Stats", models.StatsCommand{}, &statsReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.Stats error:", err)
+	}
+
+	return statsReply
+}
+
+// BeginTransaction starts an optimistic transaction on the server and
+// returns its ID.
+func (s *StorageClient) BeginTransaction() uint64 {
+
+	return s.beginTransaction(models.BeginTransactionCommand{})
+}
+
+// BeginPessimisticTransaction starts a transaction that locks the keys it
+// touches, waiting up to lockTimeoutMs for each lock or the server's default
+// when it is 0.
+func (s *StorageClient) BeginPessimisticTransaction(lockTimeoutMs int) uint64 {
+
+	return s.beginTransaction(models.BeginTransactionCommand{Pessimistic: true, LockTimeoutMs: lockTimeoutMs})
+}
+
+func (s *StorageClient) beginTransaction(command models.BeginTransactionCommand) uint64 {
+
+	var transactionID uint64
+
+	err := s.client.Call("StorageServer.BeginTransaction", command, &transactionID)
+
+	if err != nil {
+		log.Fatal("StorageServer.BeginTransaction error:", err)
+	}
+
+	return transactionID
+}
+
+// The transaction calls return the server's errors, such as a conflict on
+// commit or an unknown transaction ID, instead of exiting.
+
+func (s *StorageClient) TransactionGet(transactionID uint64, key string) (string, error) {
+
+	getItem := models.TransactionCommand{TransactionID: transactionID, Key: key}
+
+	var getReply string
+
+	err := s.client.Call("StorageServer.TransactionGet", getItem, &getReply)
+
+	return getReply, err
+}
+
+// TransactionGetForUpdate reads key and locks it in a pessimistic transaction.
+func (s *StorageClient) TransactionGetForUpdate(transactionID uint64, key string) (string, error) {
+
+	getItem := models.TransactionCommand{TransactionID: transactionID, Key: key, ForUpdate: true}
+
+	var getReply string
+
+	err := s.client.Call("StorageServer.TransactionGet", getItem, &getReply)
+
+	return getReply, err
+}
+
+func (s *StorageClient) TransactionPut(transactionID uint64, key string, value string) error {
+
+	putItem := models.TransactionCommand{TransactionID: transactionID, Key: key, Value: value}
+
+	var putReply bool
+
+	return s.client.Call("StorageServer.TransactionPut", putItem, &putReply)
+}
+
+func (s *StorageClient) TransactionDelete(transactionID uint64, key string) error {
+
+	deleteItem := models.TransactionCommand{TransactionID: transactionID, Key: key}
+
+	var deleteReply bool
+
+	return s.client.Call("StorageServer.TransactionDelete", deleteItem, &deleteReply)
+}
+
+func (s *StorageClient) CommitTransaction(transactionID uint64) error {
+
+	commitItem := models.TransactionCommand{TransactionID: transactionID}
+
+	var commitReply bool
+
+	return s.client.Call("StorageServer.CommitTransaction", commitItem, &commitReply)
+}
+
+func (s *StorageClient) RollbackTransaction(transactionID uint64) error {
+
+	rollbackItem := models.TransactionCommand{TransactionID: transactionID}
+
+	var rollbackReply bool
+
+	return s.client.Call("StorageServer.RollbackTransaction", rollbackItem, &rollbackReply)
+}
+
+// LockStatus lists the key locks held by pessimistic transactions.
+func (s *StorageClient) LockStatus() []models.LockInfo {
+
+	var locksReply []models.LockInfo
+
+	err := s.client.Call("StorageServer.LockStatus", models.LockStatusCommand{}, &locksReply)
+
+	if err != nil {
+		log.Fatal("StorageServer.LockStatus error:", err)
+	}
+
+	return locksReply
+}
@@ -1,37 +1,341 @@
 package storageservice
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"pkvstore/internal/storageengine/configs"
+	"pkvstore/internal/storageengine/iterator"
+	"pkvstore/internal/storageengine/lsmtree"
+	"pkvstore/internal/storageengine/mergeoperator"
 	"pkvstore/internal/storageengine/store"
 	"pkvstore/pkg/models"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownTransaction = errors.New("unknown or expired transaction")
+	ErrUnboundedRange     = errors.New("delete range needs a start, an end or a prefix, or all to delete every key")
 )
 
 type StorageService struct {
 	store *store.Store
+
+	transactions      map[uint64]*openTransaction
+	transactionsMutex sync.Mutex
+}
+
+// openTransaction is a transaction clients refer to by ID, which is rolled
+// back once left idle for TransactionConfig.IdleTimeoutMs.
+type openTransaction struct {
+	txn      *store.Txn
+	lastUsed time.Time
 }
 
 func NewStorageService() *StorageService {
-	return &StorageService{
-		store: store.NewStore(),
+	service := &StorageService{
+		store:        store.NewStore(),
+		transactions: make(map[uint64]*openTransaction),
+	}
+
+	// the merge command adds integers
+	service.store.SetMergeOperator(mergeoperator.Int64Add{})
+
+	if idleTimeoutMs := configs.GetStorageEngineConfig().TransactionConfig.IdleTimeoutMs; idleTimeoutMs > 0 {
+		go service.expireTransactions(time.Duration(idleTimeoutMs) * time.Millisecond)
+	}
+
+	return service
+}
+
+// expireTransactions rolls back the transactions no command used for
+// timeout, so that clients that went away do not hold key locks or keep old
+// versions from being compacted forever.
+func (s *StorageService) expireTransactions(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired := make([]*store.Txn, 0)
+
+		s.transactionsMutex.Lock()
+		for transactionID, open := range s.transactions {
+			if time.Since(open.lastUsed) > timeout {
+				expired = append(expired, open.txn)
+				delete(s.transactions, transactionID)
+			}
+		}
+		s.transactionsMutex.Unlock()
+
+		for _, txn := range expired {
+			// a deadlock victim is already rolled back
+			if err := txn.Rollback(); err != nil && err != store.ErrTransactionClosed {
+				log.Println("Rolling back idle transaction:", err)
+			}
+		}
 	}
 }
 
 func (s *StorageService) Put(command models.PutCommand) error {
 
-	s.store.Put(command.Key, command.Value)
+	if command.TTL < 0 {
+		return store.ErrInvalidTTL
+	}
+
+	if command.TTL > 0 {
+		return s.store.PutWithTTL(command.Key, command.Value, command.TTL)
+	}
 
-	return nil
+	return s.store.Put(command.Key, command.Value)
 }
 
 func (s *StorageService) Get(command models.GetCommand) (string, error) {
 
-	result := s.store.Get(command.Key)
+	result, err := s.store.Get(command.Key, nil)
+
+	if err != nil {
+		return "", err
+	}
 
 	return result.Value, nil
 }
 
 func (s *StorageService) Delete(command models.DeleteCommand) error {
 
-	s.store.Delete(command.Key)
+	return s.store.Delete(command.Key)
+}
+
+func (s *StorageService) DeleteRange(command models.DeleteRangeCommand) error {
+
+	if command.All {
+		return s.store.DeleteRange("", "")
+	}
+
+	if command.Start == "" && command.End == "" && command.Prefix == "" {
+		return ErrUnboundedRange
+	}
+
+	if command.Prefix != "" {
+		return s.store.DeleteRange(command.Prefix, iterator.PrefixEnd(command.Prefix))
+	}
+
+	return s.store.DeleteRange(command.Start, command.End)
+}
+
+func (s *StorageService) Merge(command models.MergeCommand) error {
+
+	return s.store.Merge(command.Key, command.Operand)
+}
+
+// CompareAndSwap reports whether the key held the expected value and was
+// swapped.
+func (s *StorageService) CompareAndSwap(command models.CompareAndSwapCommand) (bool, error) {
+
+	return s.store.CompareAndSwap(command.Key, command.ExpectedValue, command.NewValue)
+}
+
+func (s *StorageService) PutIfAbsent(command models.PutIfAbsentCommand) (bool, error) {
+
+	return s.store.PutIfAbsent(command.Key, command.Value)
+}
+
+func (s *StorageService) DeleteIfEquals(command models.DeleteIfEqualsCommand) (bool, error) {
+
+	return s.store.DeleteIfEquals(command.Key, command.ExpectedValue)
+}
+
+func (s *StorageService) WriteBatch(command models.WriteBatchCommand) error {
+
+	batch := lsmtree.NewWriteBatch()
+
+	for _, operation := range command.Operations {
+		switch operation.Type {
+		case models.BatchPut:
+			batch.Put(operation.Key, operation.Value)
+		case models.BatchDelete:
+			batch.Delete(operation.Key)
+		case models.BatchDeleteRange:
+			batch.DeleteRange(operation.Key, operation.End)
+		default:
+			return fmt.Errorf("unknown batch operation %d", operation.Type)
+		}
+	}
+
+	return s.store.Write(batch)
+}
+
+func (s *StorageService) Scan(command models.ScanCommand) ([]models.KeyValue, error) {
+
+	var it *iterator.Iterator
+
+	if command.Prefix != "" {
+		it = s.store.NewPrefixIterator(command.Prefix, nil)
+	} else {
+		it = s.store.NewIterator(command.Start, command.End, nil)
+	}
+
+	items := make([]models.KeyValue, 0)
+
+	for ; it.Valid() && (command.Limit <= 0 || len(items) < command.Limit); it.Next() {
+		items = append(items, models.KeyValue{Key: it.Key(), Value: it.Value()})
+	}
+
+	if err := it.Close(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (s *StorageService) Stats(command models.StatsCommand) (*models.Stats, error) {
+
+	stats := s.store.Stats()
+
+	return &models.Stats{
+		ActiveMemtableSize: stats.ActiveMemtableSize,
+		ImmutableMemtables: stats.ImmutableMemtables,
+		SSTablesPerLevel:   stats.SSTablesPerLevel,
+		BytesPerLevel:      stats.BytesPerLevel,
+		CompactionStyle:    stats.CompactionStyle.String(),
+		DroppedSSTables:    stats.DroppedSSTables,
+		DroppedBytes:       stats.DroppedBytes,
+		WriteStallState:    stats.WriteStall.State.String(),
+		DelayedWrites:      stats.WriteStall.DelayedWrites,
+		StoppedWrites:      stats.WriteStall.StoppedWrites,
+		DelayedDuration:    stats.WriteStall.DelayedDuration,
+		StoppedDuration:    stats.WriteStall.StoppedDuration,
+	}, nil
+}
+
+// BeginTransaction starts a transaction and returns the ID later commands
+// refer to it by.
+func (s *StorageService) BeginTransaction(command models.BeginTransactionCommand) (uint64, error) {
+
+	var txn *store.Txn
+
+	if command.Pessimistic {
+		txn = s.store.BeginPessimisticTransaction(time.Duration(command.LockTimeoutMs) * time.Millisecond)
+	} else {
+		txn = s.store.BeginTransaction()
+	}
+
+	s.transactionsMutex.Lock()
+	defer s.transactionsMutex.Unlock()
+
+	s.transactions[txn.ID()] = &openTransaction{txn: txn, lastUsed: time.Now()}
+
+	return txn.ID(), nil
+}
+
+func (s *StorageService) transaction(transactionID uint64) (*store.Txn, error) {
+
+	s.transactionsMutex.Lock()
+	defer s.transactionsMutex.Unlock()
+
+	open, exists := s.transactions[transactionID]
+
+	if !exists {
+		return nil, ErrUnknownTransaction
+	}
+
+	open.lastUsed = time.Now()
+
+	return open.txn, nil
+}
+
+// endTransaction forgets the transaction, which is committed or rolled back.
+func (s *StorageService) endTransaction(transactionID uint64) (*store.Txn, error) {
+
+	s.transactionsMutex.Lock()
+	defer s.transactionsMutex.Unlock()
+
+	open, exists := s.transactions[transactionID]
+
+	if !exists {
+		return nil, ErrUnknownTransaction
+	}
+
+	delete(s.transactions, transactionID)
+
+	return open.txn, nil
+}
+
+func (s *StorageService) TransactionGet(command models.TransactionCommand) (string, error) {
+
+	txn, err := s.transaction(command.TransactionID)
+
+	if err != nil {
+		return "", err
+	}
+
+	get := txn.Get
+
+	if command.ForUpdate {
+		get = txn.GetForUpdate
+	}
+
+	result, err := get(command.Key)
+
+	if err != nil {
+		return "", err
+	}
+
+	return result.Value, nil
+}
+
+func (s *StorageService) TransactionPut(command models.TransactionCommand) error {
+
+	txn, err := s.transaction(command.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	return txn.Put(command.Key, command.Value)
+}
+
+func (s *StorageService) TransactionDelete(command models.TransactionCommand) error {
+
+	txn, err := s.transaction(command.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	return txn.Delete(command.Key)
+}
+
+func (s *StorageService) CommitTransaction(command models.TransactionCommand) error {
+
+	txn, err := s.endTransaction(command.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	return txn.Commit()
+}
+
+func (s *StorageService) RollbackTransaction(command models.TransactionCommand) error {
+
+	txn, err := s.endTransaction(command.TransactionID)
+
+	if err != nil {
+		return err
+	}
+
+	return txn.Rollback()
+}
+
+// LockStatus lists the keys locked by pessimistic transactions.
+func (s *StorageService) LockStatus(command models.LockStatusCommand) ([]models.LockInfo, error) {
+
+	locks := make([]models.LockInfo, 0)
+
+	for _, lock := range s.store.LockStatus() {
+		locks = append(locks, models.LockInfo{Key: lock.Key, TransactionID: lock.TransactionID, Waiters: lock.Waiters})
+	}
 
-	return nil
+	return locks, nil
 }